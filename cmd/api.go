@@ -0,0 +1,512 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	connection "github.com/jdrivas/conman"
+	t "github.com/jdrivas/termtext"
+	config "github.com/jdrivas/vconfig"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+/*
+API Commands
+
+With an OpenAPI spec on the connection (see openapi.go), api has a command for
+each operation, named by its operationId:
+
+	gafw api listPets --limit 10
+	gafw api showPetById --petId 3
+	gafw api createPet --name fido --tag dog
+	gafw api createPet --body @fido.json
+
+Path, query, header and cookie parameters are flags, typed from their schemas. So are
+the simple top level properties of a JSON object body: strings, numbers, booleans and
+arrays of them. Anything else goes in --body, the property flags are laid over it.
+If a name is already a flag, the parameter flag gets the parameter's location
+in front (--query-output), the property flag gets body- in front.
+
+The body is checked against the spec before it's sent, --no-validate sends it anyway.
+Help comes from the spec's summaries and descriptions: api --help lists the operations.
+
+The operation commands are built before cobra parses the command line, once the config
+file has been read, from the spec of the connection the command line uses (-c, or the
+current one). In interactive mode they're built again for each api line, so they follow
+the connection. The spec is read once a session.
+
+Shell completion (see completion) completes the operations, their flags and the values
+of the flags with an enum in the spec, parameters and body properties alike.
+*/
+
+const (
+	apiBodyFlagKey       = "body"
+	apiNoValidateFlagKey = "no-validate"
+)
+
+var apiCmd *cobra.Command
+
+// Why there aren't any operations, nil if there are.
+var apiSpecErr error
+
+const apiLong = `Call an operation from the OpenAPI spec for the current connection.
+The spec is set in the connection's openapi key, as a file or a path on the service.
+Use api --help to see the operations, api <operation> --help for their flags.`
+
+func buildAPI(mode runMode) {
+	apiCmd = &cobra.Command{
+		Use:                        "api <operation> [flags]",
+		Short:                      "Call an operation from the connection's OpenAPI spec.",
+		Long:                       apiLong,
+		SuggestionsMinimumDistance: 2,
+		Example:                    fmt.Sprintf("  %s api listPets --limit 10\n  %s api createPet --name fido --tag dog", config.AppName, config.AppName),
+		Run: func(cmd *cobra.Command, args []string) {
+			if apiSpecErr != nil {
+				fmt.Printf("%s\n", t.Error(apiSpecErr))
+				return
+			}
+			if len(args) == 0 {
+				cmd.Help()
+				return
+			}
+			msg := fmt.Sprintf("unknown operation %q", args[0])
+			if s := cmd.SuggestionsFor(args[0]); len(s) > 0 {
+				msg += fmt.Sprintf(", did you mean %s?", strings.Join(s, " or "))
+			}
+			fmt.Printf("%s\n", t.Error(errors.New(msg)))
+		},
+	}
+	rootCmd.AddCommand(apiCmd)
+}
+
+// buildAPIOperations puts the operations under api, when the command line is for api.
+// The config file has to have been read.
+func buildAPIOperations(args []string) {
+	apiCmd.RemoveCommand(apiCmd.Commands()...)
+	apiCmd.ResetFlags()
+	apiCmd.Long = apiLong
+	apiSpecErr = nil
+	if !forAPI(args) {
+		return
+	}
+	if apiSpecErr = addAPIOperations(earlyConnection(args)); apiSpecErr != nil {
+		apiCmd.Long += fmt.Sprintf("\n\nThere aren't any operations: %v.", apiSpecErr)
+	}
+}
+
+// forAPI is true for api command lines, and asking for help or completion on one.
+func forAPI(args []string) bool {
+	for i, a := range args {
+		if a == "help" || a == cobra.ShellCompRequestCmd || a == cobra.ShellCompNoDescRequestCmd {
+			args = append(append([]string{}, args[:i]...), args[i+1:]...)
+			break
+		}
+	}
+	cmd, _, err := rootCmd.Find(args)
+	return err == nil && (cmd == apiCmd || cmd.Name() == completionCmdName) // The bash script has the operations in it.
+}
+
+// apiConnection is the named connection, or the current one.
+func apiConnection(name string) (*connection.Connection, error) {
+	if name == "" {
+		return connection.GetCurrentConnection()
+	}
+	if conn, ok := connection.GetConnection(name); ok {
+		return conn, nil
+	}
+	return nil, fmt.Errorf("couldn't find connection: %q", name)
+}
+
+func addAPIOperations(name string) error {
+	conn, err := apiConnection(name)
+	if err != nil {
+		return err
+	}
+	initTransport() // The spec can be on the service.
+	doc, err := connectionOpenAPI(conn)
+	if err != nil {
+		return err
+	}
+
+	apiCmd.Long = fmt.Sprintf("Operations from %s %s for the %s connection.", doc.Info.Title, doc.Info.Version, conn.Name)
+	if doc.Info.Description != "" {
+		apiCmd.Long += "\n\n" + strings.TrimSpace(doc.Info.Description)
+	}
+
+	// The http display flags work here too.
+	apiCmd.PersistentFlags().AddFlagSet(httpCmd.PersistentFlags())
+	inherited := pflag.NewFlagSet("inherited", pflag.ContinueOnError)
+	inherited.AddFlagSet(rootCmd.PersistentFlags())
+	inherited.AddFlagSet(apiCmd.PersistentFlags())
+
+	for _, op := range doc.allOperations() {
+		if oc, err := newAPIOperation(doc, op).command(inherited); err == nil {
+			apiCmd.AddCommand(oc)
+		} else {
+			fmt.Printf("%s\n", t.Warn("Skipping %s: %v", op.OperationID, err))
+		}
+	}
+	return nil
+}
+
+// apiOperation is the command for one operation.
+type apiOperation struct {
+	doc    *openAPIDoc
+	op     *operation
+	params []*apiFlag
+	fields []*apiFlag // body properties
+	body   *schema    // JSON request body, nil if there isn't one
+	bodyIn string     // the --body value
+	noVal  bool
+}
+
+// apiFlag is a flag for a parameter or body property.
+type apiFlag struct {
+	name   string // in the spec
+	in     string // path, query, header, cookie or body
+	schema *schema
+	flag   *pflag.Flag
+}
+
+func newAPIOperation(doc *openAPIDoc, op *operation) *apiOperation {
+	return &apiOperation{doc: doc, op: op}
+}
+
+func (a *apiOperation) command(inherited *pflag.FlagSet) (*cobra.Command, error) {
+	op := a.op
+	short := op.Summary
+	if short == "" {
+		short = fmt.Sprintf("%s %s", op.Method, op.Path)
+	}
+	long := strings.TrimSpace(op.Summary + "\n\n" + op.Description)
+	long = strings.TrimSpace(fmt.Sprintf("%s\n\n%s %s", long, op.Method, op.Path))
+	cmd := &cobra.Command{
+		Use:   op.OperationID + " [flags]",
+		Short: strings.Split(short, "\n")[0],
+		Long:  long,
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			a.run(cmd)
+		},
+	}
+	if op.Deprecated {
+		cmd.Deprecated = "it's deprecated in the spec."
+	}
+
+	taken := func(name string) bool {
+		return name == "help" || inherited.Lookup(name) != nil || cmd.Flags().Lookup(name) != nil
+	}
+
+	params, err := a.doc.parameters(op)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range params {
+		s, err := a.doc.resolveSchema(p.Schema)
+		if err != nil {
+			return nil, err
+		}
+		name := p.Name
+		if taken(name) {
+			name = p.In + "-" + p.Name
+		}
+		if taken(name) {
+			return nil, fmt.Errorf("can't make a flag for %s parameter %s", p.In, p.Name)
+		}
+		af := &apiFlag{name: p.Name, in: p.In, schema: s}
+		af.flag = typedFlag(cmd.Flags(), name, s, flagUsage(p.Description, s, p.Required || p.In == "path"))
+		if p.Required || p.In == "path" {
+			cmd.MarkFlagRequired(name)
+		}
+		a.completeFlag(cmd, af)
+		a.params = append(a.params, af)
+	}
+
+	if op.RequestBody != nil {
+		rb, err := a.doc.resolveBody(op.RequestBody)
+		if err != nil {
+			return nil, err
+		}
+		if a.body, err = a.doc.resolveSchema(jsonSchema(rb.Content)); err != nil {
+			return nil, err
+		}
+		usage := "The JSON request body, or @<file>."
+		if rb.Required {
+			usage = "The JSON request body, or @<file> (required)."
+		}
+		cmd.Flags().StringVar(&a.bodyIn, apiBodyFlagKey, "", usage)
+		cmd.Flags().BoolVar(&a.noVal, apiNoValidateFlagKey, false, "Send the body without checking it against the spec.")
+
+		props, required := a.doc.properties(a.body)
+		isRequired := map[string]bool{}
+		for _, n := range required {
+			isRequired[n] = true
+		}
+		for _, n := range sortedProperties(props) {
+			s, err := a.doc.resolveSchema(props[n])
+			if err != nil || s == nil || s.ReadOnly || !simpleSchema(a.doc, s) {
+				continue
+			}
+			name := n
+			if taken(name) {
+				name = "body-" + n
+			}
+			if taken(name) {
+				continue // It can still go in --body.
+			}
+			af := &apiFlag{name: n, in: "body", schema: s}
+			af.flag = typedFlag(cmd.Flags(), name, s, flagUsage(s.Description, s, isRequired[n]))
+			a.completeFlag(cmd, af)
+			a.fields = append(a.fields, af)
+		}
+	}
+	return cmd, nil
+}
+
+// completeFlag completes the flag's values from its enum.
+// The prompt doesn't complete and cobra keeps the functions for good, so only for the shell.
+func (a *apiOperation) completeFlag(cmd *cobra.Command, af *apiFlag) {
+	s := af.schema
+	if s != nil && s.Type.name() == "array" {
+		s, _ = a.doc.resolveSchema(s.Items)
+	}
+	if interactiveSession || s == nil || len(s.Enum) == 0 {
+		return
+	}
+	values := []string{}
+	for _, v := range s.Enum {
+		values = append(values, jsonValueString(v))
+	}
+	cmd.RegisterFlagCompletionFunc(af.flag.Name, func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return values, cobra.ShellCompDirectiveNoFileComp
+	})
+}
+
+// simpleSchema is true for the schemas that can be a flag: scalars and arrays of them.
+func simpleSchema(doc *openAPIDoc, s *schema) bool {
+	switch s.Type.name() {
+	case "string", "integer", "number", "boolean":
+		return true
+	case "array":
+		items, err := doc.resolveSchema(s.Items)
+		return err == nil && items != nil && items.Type.name() != "array" && items.Type.name() != "object" && items.Type.name() != ""
+	}
+	return false
+}
+
+// typedFlag adds a flag of the schema's type.
+func typedFlag(fs *pflag.FlagSet, name string, s *schema, usage string) *pflag.Flag {
+	var typ string
+	if s != nil {
+		typ = s.Type.name()
+	}
+	switch typ {
+	case "integer":
+		fs.Int64(name, 0, usage)
+	case "number":
+		fs.Float64(name, 0, usage)
+	case "boolean":
+		fs.Bool(name, false, usage)
+	case "array":
+		fs.StringSlice(name, nil, usage)
+	default:
+		fs.String(name, "", usage)
+	}
+	return fs.Lookup(name)
+}
+
+func flagUsage(desc string, s *schema, required bool) string {
+	u := strings.TrimSpace(strings.Split(desc, "\n")[0])
+	extras := []string{}
+	if s != nil {
+		if len(s.Enum) > 0 {
+			extras = append(extras, "one of: "+enumString(s.Enum))
+		}
+		if s.Default != nil {
+			extras = append(extras, "server default: "+jsonValueString(s.Default))
+		}
+	}
+	if required {
+		extras = append(extras, "required")
+	}
+	if len(extras) > 0 {
+		u = strings.TrimSpace(fmt.Sprintf("%s (%s)", u, strings.Join(extras, ", ")))
+	}
+	return u
+}
+
+// Sending
+//
+
+func (a *apiOperation) run(cmd *cobra.Command) {
+	conn, err := connection.GetCurrentConnection()
+	if err != nil {
+		fmt.Printf("%s\n", t.Error(err))
+		return
+	}
+
+	path := a.op.Path
+	query := url.Values{}
+	headers := map[string]string{}
+	var cookies []string
+	for k, v := range conn.Headers {
+		headers[k] = v
+	}
+	for _, p := range a.params {
+		if !p.flag.Changed {
+			continue
+		}
+		vals := flagStrings(p.flag)
+		if err := a.checkEnum(p, vals); err != nil {
+			fmt.Printf("%s\n", t.Error(err))
+			return
+		}
+		switch p.in {
+		case "path":
+			path = strings.Replace(path, "{"+p.name+"}", url.PathEscape(strings.Join(vals, ",")), -1)
+		case "query":
+			for _, v := range vals {
+				query.Add(p.name, v)
+			}
+		case "header":
+			headers[p.name] = strings.Join(vals, ",")
+		case "cookie":
+			cookies = append(cookies, (&http.Cookie{Name: p.name, Value: strings.Join(vals, ",")}).String())
+		}
+	}
+	if len(cookies) > 0 { // After the connection's own.
+		for k, v := range headers {
+			if strings.EqualFold(k, "Cookie") {
+				cookies = append([]string{v}, cookies...)
+				delete(headers, k)
+			}
+		}
+		headers["Cookie"] = strings.Join(cookies, "; ")
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	body, err := a.requestBody()
+	if err != nil {
+		fmt.Printf("%s\n", t.Error(err))
+		return
+	}
+	var content interface{}
+	if body != nil {
+		if a.body != nil && !a.noVal {
+			if problems := a.doc.validate(a.body, body, true); len(problems) > 0 {
+				fmt.Printf("%s\n", t.Fail("The body doesn't match the spec for %s:", a.op.OperationID))
+				for _, p := range problems {
+					fmt.Printf("  %s\n", t.Text("%s", p))
+				}
+				fmt.Printf("%s\n", t.Info("Use --%s to send it anyway.", apiNoValidateFlagKey))
+				return
+			}
+		}
+		b, err := json.Marshal(body)
+		if err != nil {
+			fmt.Printf("%s\n", t.Error(err))
+			return
+		}
+		content = string(b)
+	}
+
+	c := *conn // Send copies the connection anyway, this one has the header parameters.
+	c.Headers = headers
+	httpDisplay(c.Send(a.op.Method, path, content, nil))
+}
+
+// requestBody is --body with the property flags laid over it, decoded as JSON.
+func (a *apiOperation) requestBody() (body interface{}, err error) {
+	if a.bodyIn != "" {
+		b, err := httpBody([]string{a.bodyIn})
+		if err != nil {
+			return nil, err
+		}
+		d := json.NewDecoder(strings.NewReader(b.(string)))
+		d.UseNumber()
+		if err := d.Decode(&body); err != nil {
+			return nil, fmt.Errorf("--%s isn't JSON: %v", apiBodyFlagKey, err)
+		}
+	}
+
+	for _, f := range a.fields {
+		if !f.flag.Changed {
+			continue
+		}
+		obj, ok := body.(map[string]interface{})
+		if body == nil {
+			obj, ok = map[string]interface{}{}, true
+			body = obj
+		}
+		if !ok {
+			return nil, fmt.Errorf("--%s needs the body to be an object", f.flag.Name)
+		}
+		if obj[f.name], err = a.flagJSON(f); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// flagJSON is the flag's value as JSON of the property's type.
+func (a *apiOperation) flagJSON(f *apiFlag) (interface{}, error) {
+	if f.schema.Type.name() != "array" {
+		return scalarJSON(f.flag.Name, f.schema, f.flag.Value.String())
+	}
+	items, _ := a.doc.resolveSchema(f.schema.Items)
+	vals := []interface{}{}
+	for _, s := range flagStrings(f.flag) {
+		v, err := scalarJSON(f.flag.Name, items, s)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
+func scalarJSON(flag string, s *schema, v string) (interface{}, error) {
+	switch s.Type.name() {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return nil, fmt.Errorf("--%s: %q isn't a number", flag, v)
+		}
+		return json.Number(v), nil
+	case "boolean":
+		return strconv.ParseBool(v)
+	}
+	return v, nil
+}
+
+// checkEnum checks parameters, the body gets checked with the rest of the body.
+func (a *apiOperation) checkEnum(p *apiFlag, vals []string) error {
+	s := p.schema
+	if s != nil && s.Type.name() == "array" {
+		s, _ = a.doc.resolveSchema(s.Items)
+	}
+	if s == nil || len(s.Enum) == 0 {
+		return nil
+	}
+	for _, v := range vals {
+		if !inEnum(v, s.Enum) && !inEnum(json.Number(v), s.Enum) {
+			return fmt.Errorf("--%s: %q isn't one of %s", p.flag.Name, v, enumString(s.Enum))
+		}
+	}
+	return nil
+}
+
+// flagStrings are the flag's values, one for most flags, any number for the slices.
+func flagStrings(f *pflag.Flag) []string {
+	if sv, ok := f.Value.(pflag.SliceValue); ok {
+		return sv.GetSlice()
+	}
+	return []string{f.Value.String()}
+}
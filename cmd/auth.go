@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	connection "github.com/jdrivas/conman"
+	"github.com/spf13/viper"
+)
+
+/*
+Connection Authentication
+
+A connection can name an auth mode in its configuration. The mode signs
+(or otherwise authorizes) each request the transport sends to that connection.
+e.g. in yaml:
+
+connections:
+      gateway:
+            serviceURL: https://abcdef.execute-api.us-west-2.amazonaws.com/prod
+            auth:
+                  mode: sigv4
+                  region: us-west-2
+                  service: execute-api
+
+Connections without an auth mode are sent as conman built them.
+*/
+
+// Configuration keys, relative to the connection.
+const (
+	authKey     = "auth" // map[string]interface{}
+	authModeKey = "mode" // string
+)
+
+// Auth mode values.
+const (
+	authSigV4Mode   = "sigv4"
+	authHTTPSigMode = "httpsig"
+)
+
+// signingDetails describes how a request was signed.
+// Displayed for --dry-run and in debug mode.
+type signingDetails interface {
+	Describe()
+}
+
+// authorizer updates the request to authorize it for the connection.
+type authorizer func(conn *connection.Connection, req *http.Request) (signingDetails, error)
+
+var authModes = map[string]authorizer{
+	authSigV4Mode:   signSigV4,
+	authHTTPSigMode: signHTTPSig,
+}
+
+// authorizeRequest applies the connection's auth mode, if any, to the request.
+func authorizeRequest(conn *connection.Connection, req *http.Request) (signingDetails, error) {
+	if conn == nil {
+		return nil, nil
+	}
+	mode := strings.ToLower(authString(conn, authModeKey))
+	if mode == "" {
+		return nil, nil
+	}
+	if auth, ok := authModes[mode]; ok {
+		return auth(conn, req)
+	}
+	return nil, fmt.Errorf("connection %q has unknown auth mode %q", conn.Name, mode)
+}
+
+// connectionKey builds a viper key for a value in the named connection's configuration.
+func connectionKey(name string, keys ...string) string {
+	return strings.Join(append([]string{connection.ConnectionsKey, name}, keys...), ".")
+}
+
+// authString looks up a string in the connection's auth configuration.
+func authString(conn *connection.Connection, key string) string {
+	return viper.GetString(connectionKey(conn.Name, authKey, key))
+}
+
+// authBool looks up a bool in the connection's auth configuration.
+func authBool(conn *connection.Connection, key string) bool {
+	return viper.GetBool(connectionKey(conn.Name, authKey, key))
+}
+
+// authStringSlice looks up a list of strings in the connection's auth configuration.
+func authStringSlice(conn *connection.Connection, key string) []string {
+	return viper.GetStringSlice(connectionKey(conn.Name, authKey, key))
+}
+
+// expandHome replaces a leading ~ in a file name with the home directory.
+func expandHome(fn string) string {
+	if strings.HasPrefix(fn, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, fn[2:])
+		}
+	}
+	return fn
+}
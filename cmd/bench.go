@@ -0,0 +1,419 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	connection "github.com/jdrivas/conman"
+	t "github.com/jdrivas/termtext"
+	config "github.com/jdrivas/vconfig"
+	"github.com/juju/ansiterm"
+	"github.com/spf13/cobra"
+)
+
+/*
+Benchmark
+
+http bench sends the same request over and over through the current connection,
+so it gets the connection's headers, auth and TLS like any other http command.
+Requests go out from -C workers (-c is already --connection) until -n requests
+have been sent, or until the --duration is up if there is one. --rate caps the
+requests per second across all of the workers.
+*/
+
+var benchCmd *cobra.Command
+
+func buildBench(mode runMode) {
+	benchCmd = &cobra.Command{
+		Use:                   "bench [flags] <method> <command> [<json-string> .... | @<file>]",
+		DisableFlagsInUseLine: true,
+		Short:                 "Load test the service with a request.",
+		Long: `Sends <method> <command> to the current service endpoint repeatedly and reports
+the latency histogram, percentiles, status code distribution and errors.
+
+The number of workers is -C, --concurrency, not -c: -c is already --connection.
+
+Use -o json or -o csv for results you can keep to compare runs.`,
+		Example: fmt.Sprintf("%s http bench -n 1000 -C 20 --rate 100/s get /users", config.AppName),
+		Args:    cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			conn, err := connection.GetCurrentConnection()
+			if err != nil {
+				fmt.Printf("%s\n", t.Error(err))
+				return
+			}
+			opts, err := getBenchOptions()
+			if err != nil {
+				fmt.Printf("%s\n", t.Error(err))
+				return
+			}
+			body, err := httpBody(args[2:])
+			if err != nil {
+				fmt.Printf("%s\n", t.Error(err))
+				return
+			}
+			r := runBench(conn, strings.ToUpper(args[0]), args[1], body, opts)
+			printOutput(r, r.Describe)
+		},
+	}
+	httpCmd.AddCommand(benchCmd)
+	initBenchFlags()
+}
+
+// Bench flags
+//
+
+var (
+	benchRequestsFlag    int
+	benchConcurrencyFlag int
+	benchDurationFlag    time.Duration
+	benchRateFlag        string
+)
+
+const (
+	benchRequestsFlagKey    = "requests"
+	benchConcurrencyFlagKey = "concurrency"
+	benchDurationFlagKey    = "duration"
+	benchRateFlagKey        = "rate"
+)
+
+func initBenchFlags() {
+	benchCmd.Flags().IntVarP(&benchRequestsFlag, benchRequestsFlagKey, "n", 200, "Number of requests to send.")
+	benchCmd.Flags().IntVarP(&benchConcurrencyFlag, benchConcurrencyFlagKey, "C", 10, "Number of requests to have in flight at once (-c is the connection).")
+	benchCmd.Flags().DurationVar(&benchDurationFlag, benchDurationFlagKey, 0, "Send requests for this long instead of a fixed number (e.g. 30s).")
+	benchCmd.Flags().StringVar(&benchRateFlag, benchRateFlagKey, "", "Maximum request rate, e.g. 100/s or 600/m.")
+}
+
+type benchOptions struct {
+	Requests    int
+	Concurrency int
+	Duration    time.Duration
+	Rate        float64 // per second, 0 for no limit.
+}
+
+func getBenchOptions() (opts benchOptions, err error) {
+	opts = benchOptions{
+		Requests:    benchRequestsFlag,
+		Concurrency: benchConcurrencyFlag,
+		Duration:    benchDurationFlag,
+	}
+	if opts.Concurrency < 1 {
+		return opts, errors.New("concurrency has to be at least 1")
+	}
+	if opts.Duration == 0 && opts.Requests < 1 {
+		return opts, errors.New("need a number of requests or a duration")
+	}
+	if benchRateFlag != "" {
+		opts.Rate, err = parseRate(benchRateFlag)
+	}
+	return opts, err
+}
+
+// parseRate reads <n>, <n>/s, <n>/m or <n>/h as requests per second.
+func parseRate(s string) (float64, error) {
+	in, per := s, time.Second
+	if i := strings.Index(s, "/"); i >= 0 {
+		switch s[i+1:] {
+		case "s":
+		case "m":
+			per = time.Minute
+		case "h":
+			per = time.Hour
+		default:
+			return 0, fmt.Errorf("rate unit must be s, m or h: %q", s)
+		}
+		s = s[:i]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(n > 0) {
+		return 0, fmt.Errorf("bad rate %q", s)
+	}
+	rate := n / per.Seconds()
+	if time.Duration(float64(time.Second)/rate) < 1 {
+		return 0, fmt.Errorf("rate %q is more than one request a nanosecond", in)
+	}
+	return rate, nil
+}
+
+// Running
+//
+
+type benchResult struct {
+	Latency time.Duration
+	Status  int
+	Err     error
+}
+
+// runBench sends requests until we've sent opts.Requests or run for opts.Duration.
+func runBench(conn *connection.Connection, method, path string, body interface{}, opts benchOptions) *benchReport {
+	jobs := make(chan struct{})
+	results := make(chan benchResult, opts.Concurrency)
+
+	// Hand out work, at the rate if there is one.
+	go func() {
+		defer close(jobs)
+		var tick <-chan time.Time
+		if opts.Rate > 0 {
+			ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		deadline := time.Now().Add(opts.Duration)
+		for sent := 0; ; sent++ {
+			if opts.Duration > 0 {
+				if time.Now().After(deadline) {
+					return
+				}
+			} else if sent >= opts.Requests {
+				return
+			}
+			if tick != nil {
+				<-tick
+			}
+			jobs <- struct{}{}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				results <- benchRequest(conn, method, path, body)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	start := time.Now()
+	var all []benchResult
+	for r := range results {
+		all = append(all, r)
+	}
+	return newBenchReport(conn, method, path, opts, time.Since(start), all)
+}
+
+func benchRequest(conn *connection.Connection, method, path string, body interface{}) (r benchResult) {
+	se, resp, err := conn.Send(method, path, body, nil)
+	if se != nil {
+		r.Latency = se.ElapsedTime
+	}
+	if ex := responseExchange(resp); ex != nil {
+		r.Latency = ex.Timing.phases().Total
+	}
+	if resp != nil {
+		r.Status = resp.StatusCode
+		resp.Body.Close() // So the connection gets reused.
+	} else {
+		r.Err = err // conman errors on non 2xx statuses, those are counted by status.
+	}
+	return r
+}
+
+// Report
+//
+
+type benchReport struct {
+	Connection     string         `json:"connection"`
+	Method         string         `json:"method"`
+	Path           string         `json:"path"`
+	Concurrency    int            `json:"concurrency"`
+	Rate           float64        `json:"rate,omitempty"`
+	Requests       int            `json:"requests"`
+	Errors         int            `json:"errors"`
+	Seconds        float64        `json:"seconds"`
+	RequestsPerSec float64        `json:"requestsPerSecond"`
+	Min            float64        `json:"minMs"`
+	Mean           float64        `json:"meanMs"`
+	P50            float64        `json:"p50Ms"`
+	P90            float64        `json:"p90Ms"`
+	P95            float64        `json:"p95Ms"`
+	P99            float64        `json:"p99Ms"`
+	Max            float64        `json:"maxMs"`
+	StatusCodes    map[int]int    `json:"statusCodes"`
+	ErrorMessages  map[string]int `json:"errorMessages,omitempty"`
+	Histogram      []benchBucket  `json:"histogram"`
+}
+
+type benchBucket struct {
+	UpTo  float64 `json:"upToMs"`
+	Count int     `json:"count"`
+}
+
+// Histogram bucket upper bounds in milliseconds, 1-2-5 steps.
+var benchBucketBounds = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 30000, 60000}
+
+func newBenchReport(conn *connection.Connection, method, path string, opts benchOptions,
+	elapsed time.Duration, results []benchResult) *benchReport {
+
+	r := &benchReport{
+		Connection:    conn.Name,
+		Method:        method,
+		Path:          path,
+		Concurrency:   opts.Concurrency,
+		Rate:          opts.Rate,
+		Requests:      len(results),
+		Seconds:       elapsed.Seconds(),
+		StatusCodes:   map[int]int{},
+		ErrorMessages: map[string]int{},
+	}
+	if r.Seconds > 0 {
+		r.RequestsPerSec = float64(r.Requests) / r.Seconds
+	}
+
+	var latencies []time.Duration
+	var total time.Duration
+	for _, res := range results {
+		if res.Err != nil {
+			r.Errors++
+			r.ErrorMessages[res.Err.Error()]++
+			if res.Status == 0 { // Never got a response.
+				continue
+			}
+		}
+		r.StatusCodes[res.Status]++
+		latencies = append(latencies, res.Latency)
+		total += res.Latency
+	}
+	if len(latencies) == 0 {
+		return r
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	r.Min = ms(latencies[0])
+	r.Max = ms(latencies[len(latencies)-1])
+	r.Mean = ms(total / time.Duration(len(latencies)))
+	r.P50 = ms(percentile(latencies, 50))
+	r.P90 = ms(percentile(latencies, 90))
+	r.P95 = ms(percentile(latencies, 95))
+	r.P99 = ms(percentile(latencies, 99))
+
+	// Buckets from the one holding the fastest to the one holding the slowest.
+	counts := make([]int, len(benchBucketBounds)+1)
+	for _, l := range latencies {
+		i := sort.SearchFloat64s(benchBucketBounds, ms(l))
+		counts[i]++
+	}
+	first, last := -1, 0
+	for i, c := range counts {
+		if c > 0 {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	for i := first; i <= last; i++ {
+		upTo := overflowBucket
+		if i < len(benchBucketBounds) {
+			upTo = benchBucketBounds[i]
+		}
+		r.Histogram = append(r.Histogram, benchBucket{UpTo: upTo, Count: counts[i]})
+	}
+	return r
+}
+
+// JSON can't do infinity, so the bucket past the last bound has an upTo of -1.
+const overflowBucket = -1.0
+
+const histogramWidth = 40
+
+// Describe prints the report.
+func (r *benchReport) Describe() {
+	w := ansiterm.NewTabWriter(os.Stdout, 4, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\t%s\n", t.Title("Target:"), t.Text("%s %s (%s)", r.Method, r.Path, r.Connection))
+	fmt.Fprintf(w, "%s\t%s\n", t.Title("Requests:"), t.Text("%d in %.2fs, %.1f/s with %d workers", r.Requests, r.Seconds, r.RequestsPerSec, r.Concurrency))
+	fmt.Fprintf(w, "%s\t%s\n", t.Title("Latency:"),
+		t.Text("min %.1fms  mean %.1fms  max %.1fms", r.Min, r.Mean, r.Max))
+	fmt.Fprintf(w, "%s\t%s\n", t.Title("Percentiles:"),
+		t.Text("p50 %.1fms  p90 %.1fms  p95 %.1fms  p99 %.1fms", r.P50, r.P90, r.P95, r.P99))
+	w.Flush()
+
+	fmt.Printf("\n%s\n", t.Title("Histogram"))
+	most := 0
+	for _, b := range r.Histogram {
+		if b.Count > most {
+			most = b.Count
+		}
+	}
+	w = ansiterm.NewTabWriter(os.Stdout, 4, 4, 2, ' ', 0)
+	for _, b := range r.Histogram {
+		label := fmt.Sprintf("<= %gms", b.UpTo)
+		if b.UpTo == overflowBucket {
+			label = fmt.Sprintf("> %gms", benchBucketBounds[len(benchBucketBounds)-1])
+		}
+		bar := ""
+		if most > 0 {
+			bar = strings.Repeat("#", b.Count*histogramWidth/most)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", t.Title("  %s", label), t.Text("%d", b.Count), t.Info("%s", bar))
+	}
+	w.Flush()
+
+	fmt.Printf("\n%s\n", t.Title("Status Codes"))
+	w = ansiterm.NewTabWriter(os.Stdout, 4, 4, 2, ' ', 0)
+	for _, c := range sortedStatusCodes(r.StatusCodes) {
+		fmt.Fprintf(w, "  %s\t%s\n", httpStatusText(c), t.Text("%d", r.StatusCodes[c]))
+	}
+	w.Flush()
+
+	if r.Errors > 0 {
+		fmt.Printf("\n%s\n", t.Fail("Errors: %d", r.Errors))
+		for m, n := range r.ErrorMessages {
+			fmt.Printf("  %s %s\n", t.Text("%d", n), t.Fail("%s", m))
+		}
+	}
+}
+
+func sortedStatusCodes(codes map[int]int) (sorted []int) {
+	for c := range codes {
+		sorted = append(sorted, c)
+	}
+	sort.Ints(sorted)
+	return sorted
+}
+
+func httpStatusText(code int) string {
+	s := fmt.Sprintf("%d %s", code, http.StatusText(code))
+	switch {
+	case code < 300:
+		return t.Success("%s", s)
+	case code < 400:
+		return t.Warn("%s", s)
+	}
+	return t.Fail("%s", s)
+}
+
+var benchCSVHeader = []string{"connection", "method", "path", "concurrency", "requests", "errors", "seconds",
+	"requests_per_second", "min_ms", "mean_ms", "p50_ms", "p90_ms", "p95_ms", "p99_ms", "max_ms",
+	"status_2xx", "status_3xx", "status_4xx", "status_5xx"}
+
+// printCSV prints a header and a single row, so runs are easy to append and compare.
+func (r *benchReport) printCSV() {
+	classes := make([]int, 6)
+	for c, n := range r.StatusCodes {
+		if c/100 < len(classes) {
+			classes[c/100] += n
+		}
+	}
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+	w := csv.NewWriter(os.Stdout)
+	w.Write(benchCSVHeader)
+	w.Write([]string{r.Connection, r.Method, r.Path, strconv.Itoa(r.Concurrency), strconv.Itoa(r.Requests),
+		strconv.Itoa(r.Errors), f(r.Seconds), f(r.RequestsPerSec), f(r.Min), f(r.Mean), f(r.P50),
+		f(r.P90), f(r.P95), f(r.P99), f(r.Max),
+		strconv.Itoa(classes[2]), strconv.Itoa(classes[3]), strconv.Itoa(classes[4]), strconv.Itoa(classes[5])})
+	w.Flush()
+}
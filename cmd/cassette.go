@@ -0,0 +1,496 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	connection "github.com/jdrivas/conman"
+	t "github.com/jdrivas/termtext"
+	"github.com/spf13/viper"
+)
+
+/*
+Cassettes
+
+--record <cassette> saves every request and response that goes through the transport
+to a file. --replay <cassette> answers requests from the file instead of the network,
+so you can work without the service (or the network):
+
+	gafw --record users.json http get /users
+	gafw --replay users.json http get /users
+
+Recording adds to the cassette if it's already there. The cassette is written
+when the command is done (or the proxy stops). Credential headers
+(Authorization, cookies, API keys), the headers the connection adds, anything with
+the connection's token or auth secrets in it and credential query parameters
+(api_key, access_token ...) are saved as REDACTED. The query is matched that way too.
+
+A request replays the recorded response with the same method, path and query,
+or whatever the match rules say:
+
+	--match method,path,query,body
+
+from method, host, path, query (in any order) and body (by SHA-256 hash).
+It can go in the config file too:
+
+cassette:
+      match: [method, path, query, body]
+
+The same request made more than once gets the recorded responses in order, and
+the last one after that. A request that isn't in the cassette is an error that
+says what's close.
+
+The file is versioned JSON, see cassetteVersion.
+*/
+
+const (
+	cassetteRecordKey = "cassette.record" // string
+	cassetteReplayKey = "cassette.replay" // string
+	cassetteMatchKey  = "cassette.match"  // []string or a comma separated string
+)
+
+// The cassette file format. Bump it when the format changes in a way old readers can't handle.
+const cassetteVersion = 1
+
+var defaultMatchRules = []string{"method", "path", "query"}
+var matchRules = []string{"method", "host", "path", "query", "body"}
+
+// Headers that don't get saved.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "X-Amz-Security-Token"}
+
+// Query parameters that don't get saved, compared without case.
+var sensitiveParams = []string{"api_key", "apikey", "api-key", "key", "access_token", "id_token", "refresh_token", "token",
+	"auth", "client_secret", "secret", "password", "sig", "signature", "x-amz-signature", "x-amz-credential", "x-amz-security-token"}
+
+// Connection auth settings that aren't secret, everything else is.
+var publicAuthSettings = []string{authModeKey, sigV4RegionKey, sigV4ServiceKey, sigV4ProfileKey, sigV4UnsignedPayloadKey,
+	httpSigAlgorithmKey, httpSigKeyFileKey, httpSigLabelKey, httpSigComponentsKey, httpSigVerifyAlgorithmKey, httpSigVerifyKeyFileKey}
+
+const redacted = "REDACTED"
+
+type cassette struct {
+	Version      int            `json:"version"`
+	Interactions []*interaction `json:"interactions"`
+
+	path  string
+	mu    sync.Mutex
+	dirty bool
+	plays map[string]int // Replays by match key.
+}
+
+type interaction struct {
+	Recorded   time.Time        `json:"recorded"`
+	Connection string           `json:"connection,omitempty"`
+	Millis     float64          `json:"ms"`
+	Request    cassetteRequest  `json:"request"`
+	Response   cassetteResponse `json:"response"`
+
+	secrets connectionSecrets // For redacted, not saved.
+}
+
+// connectionSecrets are what the connection puts in a request that shouldn't get out.
+type connectionSecrets struct {
+	headers []string // Names, the connection's headers.
+	values  []string // The token and auth secrets.
+}
+
+type cassetteRequest struct {
+	Method   string      `json:"method"`
+	URL      string      `json:"url"`
+	Headers  http.Header `json:"headers,omitempty"`
+	Body     string      `json:"body,omitempty"`
+	Encoding string      `json:"encoding,omitempty"` // base64 for bodies that aren't text
+	BodyHash string      `json:"bodyHash,omitempty"`
+}
+
+type cassetteResponse struct {
+	Status   int         `json:"status"`
+	Headers  http.Header `json:"headers,omitempty"`
+	Body     string      `json:"body,omitempty"`
+	Encoding string      `json:"encoding,omitempty"`
+}
+
+// The cassettes used this session, by file name.
+var (
+	cassettes   = map[string]*cassette{}
+	cassettesMu sync.Mutex
+)
+
+// loadCassette returns the cassette for the file, reading it if it's there.
+func loadCassette(path string, mustExist bool) (*cassette, error) {
+	cassettesMu.Lock()
+	defer cassettesMu.Unlock()
+	if c, ok := cassettes[path]; ok {
+		return c, nil
+	}
+	c := &cassette{Version: cassetteVersion, path: path, plays: map[string]int{}}
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err) && !mustExist:
+	case err != nil:
+		return nil, fmt.Errorf("can't read cassette: %v", err)
+	default:
+		if err := json.Unmarshal(b, c); err != nil {
+			return nil, fmt.Errorf("cassette %s: %v", path, err)
+		}
+		if c.Version > cassetteVersion {
+			return nil, fmt.Errorf("cassette %s is version %d, we only know up to %d", path, c.Version, cassetteVersion)
+		}
+	}
+	cassettes[path] = c
+	return c, nil
+}
+
+// saveCassettes writes out the cassettes that have been recorded to.
+func saveCassettes() {
+	cassettesMu.Lock()
+	defer cassettesMu.Unlock()
+	for _, c := range cassettes {
+		if err := c.save(); err != nil {
+			fmt.Printf("%s\n", t.Error(err))
+		}
+	}
+}
+
+func (c *cassette) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	c.Version = cassetteVersion
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(c.path, append(b, '\n'), 0600)
+	}
+	if err != nil {
+		return fmt.Errorf("saving cassette %s: %v", c.path, err)
+	}
+	c.dirty = false
+	return nil
+}
+
+// Recording
+//
+
+func (c *cassette) add(in *interaction) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Interactions = append(c.Interactions, in)
+	c.dirty = true
+}
+
+// newInteraction is the exchange as it gets saved, without the credentials.
+func newInteraction(ex *exchange, req *http.Request, reqBody []byte, resp *http.Response, body []byte) *interaction {
+	return captureInteraction(ex, req, reqBody, resp, body).redacted()
+}
+
+// captureInteraction is the exchange as it was sent.
+func captureInteraction(ex *exchange, req *http.Request, reqBody []byte, resp *http.Response, body []byte) *interaction {
+	in := &interaction{Recorded: time.Now().UTC()}
+	if ex != nil {
+		if ex.Connection != nil {
+			in.Connection = ex.Connection.Name
+			in.secrets = secretsFor(ex.Connection)
+		}
+		in.Millis = float64(ex.Timing.Done.Sub(ex.Timing.Start)) / float64(time.Millisecond)
+	}
+	in.Request = cassetteRequest{
+		Method:   req.Method,
+		URL:      req.URL.String(),
+		Headers:  req.Header.Clone(),
+		BodyHash: bodyHash(reqBody),
+	}
+	in.Request.Body, in.Request.Encoding = encodeBody(reqBody)
+	in.Response = cassetteResponse{Status: resp.StatusCode, Headers: resp.Header.Clone()}
+	in.Response.Body, in.Response.Encoding = encodeBody(body)
+	return in
+}
+
+func (in *interaction) redacted() *interaction {
+	r := *in
+	r.Request.URL = redactURL(in.Request.URL)
+	r.Request.Headers = in.secrets.redact(redactHeaders(in.Request.Headers), true)
+	r.Response.Headers = in.secrets.redact(redactHeaders(in.Response.Headers), false)
+	r.Request.Body = in.secrets.redactBody(in.Request.Body, in.Request.Encoding)
+	r.Response.Body = in.secrets.redactBody(in.Response.Body, in.Response.Encoding)
+	return &r
+}
+
+func redactHeaders(h http.Header) http.Header {
+	h = h.Clone()
+	for _, k := range sensitiveHeaders {
+		if _, ok := h[k]; ok {
+			h[k] = []string{redacted}
+		}
+	}
+	return h
+}
+
+// redact takes out the connection's headers, on a request, and anything with a secret in it.
+func (cs connectionSecrets) redact(h http.Header, request bool) http.Header {
+	if request {
+		for _, k := range cs.headers {
+			if _, ok := h[http.CanonicalHeaderKey(k)]; ok {
+				h[http.CanonicalHeaderKey(k)] = []string{redacted}
+			}
+		}
+	}
+	for k, vs := range h {
+		for _, v := range vs {
+			if cs.in(v) {
+				h[k] = []string{redacted}
+				break
+			}
+		}
+	}
+	return h
+}
+
+// redactBody takes the secrets out of a text body, the rest of it stays.
+// Matching uses the body hash, so a cassette still replays.
+func (cs connectionSecrets) redactBody(body, encoding string) string {
+	if encoding != "" {
+		return body
+	}
+	for _, s := range cs.values {
+		body = strings.Replace(body, s, redacted, -1)
+	}
+	return body
+}
+
+func (cs connectionSecrets) in(v string) bool {
+	for _, s := range cs.values {
+		if strings.Contains(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretsFor collects the connection's headers and secrets.
+func secretsFor(conn *connection.Connection) connectionSecrets {
+	cs := connectionSecrets{}
+	for k := range conn.Headers {
+		cs.headers = append(cs.headers, k)
+	}
+	if conn.AuthToken != "" {
+		cs.values = append(cs.values, conn.AuthToken)
+	}
+	for k, v := range viper.GetStringMap(connectionKey(conn.Name, authKey)) {
+		if s, ok := v.(string); ok && s != "" && !containsFold(publicAuthSettings, k) {
+			cs.values = append(cs.values, s)
+		}
+	}
+	return cs
+}
+
+// redactURL takes the credentials out of the user info and the query.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	changed := false
+	if u.User != nil {
+		u.User, changed = url.User(redacted), true
+	}
+	if u.RawQuery != "" {
+		q, qchanged := redactQuery(u.Query())
+		if qchanged {
+			u.RawQuery, changed = q.Encode(), true
+		}
+	}
+	if !changed {
+		return s
+	}
+	return u.String()
+}
+
+func redactQuery(q url.Values) (url.Values, bool) {
+	changed := false
+	for k := range q {
+		if containsFold(sensitiveParams, k) {
+			q[k] = []string{redacted}
+			changed = true
+		}
+	}
+	return q, changed
+}
+
+func containsFold(l []string, s string) bool {
+	for _, e := range l {
+		if strings.EqualFold(e, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func bodyHash(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// encodeBody keeps text as it is and base64s everything else.
+func encodeBody(b []byte) (string, string) {
+	if utf8.Valid(b) {
+		return string(b), ""
+	}
+	return base64.StdEncoding.EncodeToString(b), "base64"
+}
+
+func decodeBody(s, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return []byte(s), nil
+}
+
+// Replaying
+//
+
+// replay finds the response for the request.
+func (c *cassette) replay(req *http.Request, reqBody []byte) (*http.Response, error) {
+	rules := cassetteMatchRules()
+	if err := checkMatchRules(rules); err != nil {
+		return nil, err
+	}
+	key := matchKey(rules, req.Method, req.URL, bodyHash(reqBody))
+
+	c.mu.Lock()
+	var found []*interaction
+	for _, in := range c.Interactions {
+		if u, err := url.Parse(in.Request.URL); err == nil && matchKey(rules, in.Request.Method, u, in.Request.BodyHash) == key {
+			found = append(found, in)
+		}
+	}
+	n := c.plays[key]
+	c.plays[key]++
+	c.mu.Unlock()
+
+	if len(found) == 0 {
+		return nil, c.missing(req, rules)
+	}
+	if n >= len(found) {
+		n = len(found) - 1
+	}
+	in := found[n]
+
+	body, err := decodeBody(in.Response.Body, in.Response.Encoding)
+	if err != nil {
+		return nil, fmt.Errorf("cassette %s: %v", c.path, err)
+	}
+	header := in.Response.Headers.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", in.Response.Status, http.StatusText(in.Response.Status)),
+		StatusCode:    in.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// missing explains that the request isn't in the cassette, with the near misses.
+func (c *cassette) missing(req *http.Request, rules []string) error {
+	msg := fmt.Sprintf("%s %s isn't in cassette %s (matching on %s)", req.Method, req.URL.RequestURI(), c.path, strings.Join(rules, ", "))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	near := []string{}
+	for _, in := range c.Interactions {
+		u, err := url.Parse(in.Request.URL)
+		if err == nil && strings.EqualFold(in.Request.Method, req.Method) && u.Path == req.URL.Path {
+			near = append(near, fmt.Sprintf("%s %s", in.Request.Method, u.RequestURI()))
+		}
+	}
+	if len(near) > 0 {
+		if len(near) > 5 {
+			near = append(near[:5], "...")
+		}
+		msg += ", it has: " + strings.Join(near, ", ")
+	}
+	return errors.New(msg)
+}
+
+// matchKey is the parts of the request the rules compare.
+func matchKey(rules []string, method string, u *url.URL, hash string) string {
+	parts := []string{}
+	for _, r := range rules {
+		switch r {
+		case "method":
+			parts = append(parts, strings.ToUpper(method))
+		case "host":
+			parts = append(parts, strings.ToLower(u.Host))
+		case "path":
+			parts = append(parts, u.Path)
+		case "query":
+			q, _ := redactQuery(u.Query())    // As it was recorded.
+			parts = append(parts, q.Encode()) // Sorted by key.
+		case "body":
+			parts = append(parts, hash)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// cassetteMatchRules are the configured rules, or the defaults.
+func cassetteMatchRules() []string {
+	var rules []string
+	switch v := viper.Get(cassetteMatchKey).(type) {
+	case string:
+		rules = strings.Split(strings.Trim(v, "[]"), ",")
+	case []interface{}:
+		for _, r := range v {
+			rules = append(rules, fmt.Sprintf("%v", r))
+		}
+	case []string:
+		rules = v
+	}
+	valid := []string{}
+	for _, r := range rules {
+		if r = strings.ToLower(strings.TrimSpace(r)); r != "" {
+			valid = append(valid, r)
+		}
+	}
+	if len(valid) == 0 {
+		return defaultMatchRules
+	}
+	return valid
+}
+
+// checkMatchRules makes sure the rules are ones we know.
+func checkMatchRules(rules []string) error {
+	for _, r := range rules {
+		ok := false
+		for _, m := range matchRules {
+			ok = ok || r == m
+		}
+		if !ok {
+			return fmt.Errorf("unknown match rule %q, use some of: %s", r, strings.Join(matchRules, ", "))
+		}
+	}
+	return nil
+}
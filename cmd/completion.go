@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	t "github.com/jdrivas/termtext"
+	config "github.com/jdrivas/vconfig"
+	"github.com/spf13/cobra"
+)
+
+/*
+Shell Completion
+
+completion writes a script for the shell to complete commands, flags and values with:
+
+	source <(gafw completion bash)
+	gafw completion fish | source
+
+Fish asks us for each completion, so it's always up to date. The bash script has the
+commands in it as they were when it was written, including the api operations for the
+connection then, and asks us for the values of flags that have them (see api.go).
+*/
+
+const completionCmdName = "completion"
+
+func buildCompletion(mode runMode) {
+	if mode == interactive {
+		return
+	}
+	rootCmd.AddCommand(&cobra.Command{
+		Use:       completionCmdName + " bash|fish",
+		Short:     "Write a shell completion script.",
+		Long:      "Write a script for bash or fish to complete commands, flags and their values with.",
+		Example:   fmt.Sprintf("  source <(%s completion bash)\n  %s completion fish | source", config.AppName, config.AppName),
+		Args:      cobra.ExactValidArgs(1),
+		ValidArgs: []string{"bash", "fish"},
+		Run: func(cmd *cobra.Command, args []string) {
+			var err error
+			switch args[0] {
+			case "bash":
+				err = rootCmd.GenBashCompletion(os.Stdout)
+			case "fish":
+				err = rootCmd.GenFishCompletion(os.Stdout, true)
+			}
+			if err != nil {
+				fmt.Printf("%s\n", t.Error(err))
+			}
+		},
+	})
+}
@@ -20,7 +20,7 @@ func buildConnection(runMode) {
 		Args:    cobra.MaximumNArgs(0),
 		Run: func(cmd *cobra.Command, args []string) {
 			conns := connection.GetAllConnections()
-			t.List(conns, nil, nil)
+			printOutput(connectionViews(conns, false), func() { t.List(conns, nil, nil) })
 		},
 	})
 
@@ -38,7 +38,7 @@ func buildConnection(runMode) {
 					fconns = append(fconns, c)
 				}
 			}
-			t.Describe(fconns, nil, nil)
+			printOutput(connectionViews(fconns, true), func() { t.Describe(fconns, nil, nil) })
 		},
 	})
 
@@ -57,3 +57,30 @@ func buildConnection(runMode) {
 	})
 
 }
+
+// connectionView is a connection for the non-table output formats.
+type connectionView struct {
+	Name       string            `json:"name"`
+	ServiceURL string            `json:"serviceURL"`
+	Current    bool              `json:"current"`
+	AuthToken  string            `json:"authToken,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+}
+
+// connectionViews describes the connections, with the auth token and headers if details.
+func connectionViews(conns connection.ConnectionList, details bool) []connectionView {
+	current := ""
+	if c, err := connection.GetCurrentConnection(); err == nil {
+		current = c.Name
+	}
+	cvs := []connectionView{}
+	for _, c := range conns {
+		cv := connectionView{Name: c.Name, ServiceURL: c.ServiceURL, Current: c.Name == current}
+		if details {
+			cv.AuthToken = c.AuthToken
+			cv.Headers = c.Headers
+		}
+		cvs = append(cvs, cv)
+	}
+	return cvs
+}
@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	connection "github.com/jdrivas/conman"
+	t "github.com/jdrivas/termtext"
+	config "github.com/jdrivas/vconfig"
+	"github.com/spf13/viper"
+)
+
+/*
+Contract Checks
+
+When the connection has an OpenAPI spec (see openapi.go), responses that get
+displayed are checked against it:
+
+	the request is an operation in the spec,
+	the status is one the operation lists (or a range like 2XX, or default),
+	the Content-Type is one listed for that status,
+	and a JSON body matches the schema.
+
+Problems are reported after the response, with a JSON pointer to where in the body
+they are (#/data/0/email). They go to stderr for the non-table formats so they
+don't get mixed into the output. -v reports when everything matches.
+
+--strict makes the command exit non-zero when there's a problem, for CI smoke checks:
+
+	gafw http get /users --strict -o json > /dev/null && echo ok
+
+It does when the request fails too, when there's no response (connection refused, a
+TLS failure) or the status is an error.
+*/
+
+// Exit codes for --strict, when a request fails and when a response doesn't match the spec.
+const (
+	requestExitCode  = 2
+	contractExitCode = 3
+)
+
+type contractCheck struct {
+	Operation string   `json:"operation"`
+	Method    string   `json:"method"`
+	Path      string   `json:"path"`
+	Status    int      `json:"status"`
+	Problems  []string `json:"problems"`
+}
+
+// checkContract checks the response against the connection's spec.
+// It's nil if there's no spec to check against. The body is left for the display.
+func checkContract(resp *http.Response, conn *connection.Connection) *contractCheck {
+	if resp == nil || resp.Request == nil || conn == nil || viper.GetString(connectionKey(conn.Name, openAPIKey)) == "" {
+		return nil
+	}
+	req := resp.Request
+	cc := &contractCheck{Method: req.Method, Path: servicePath(conn, req.URL), Status: resp.StatusCode}
+	doc, err := connectionOpenAPI(conn)
+	if err != nil {
+		cc.problem("can't check the response: %v", err)
+		return cc
+	}
+	op, _ := doc.findOperation(cc.Method, cc.Path)
+	if op == nil {
+		cc.problem("%s %s isn't in the spec", cc.Method, cc.Path)
+		return cc
+	}
+	cc.Operation = op.OperationID
+
+	reply, err := doc.resolveReply(op.reply(resp.StatusCode))
+	if err != nil {
+		cc.problem("%v", err)
+		return cc
+	}
+	if reply == nil {
+		cc.problem("status %d isn't one of the responses for %s (%s)", resp.StatusCode, op.OperationID, strings.Join(op.statuses(), ", "))
+		return cc
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		cc.problem("can't read the body: %v", err)
+		return cc
+	}
+
+	if len(reply.Content) == 0 {
+		if len(bytes.TrimSpace(body)) > 0 {
+			cc.problem("there's a body, the spec doesn't have one for status %d", resp.StatusCode)
+		}
+		return cc
+	}
+	ct := resp.Header.Get("Content-Type")
+	mt, key := matchContent(reply.Content, ct)
+	if mt == nil {
+		cc.problem("Content-Type %q isn't one of %s", ct, strings.Join(contentTypes(reply.Content), ", "))
+		return cc
+	}
+	if !isJSONContent(key) || mt.Schema == nil {
+		return cc
+	}
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		cc.problem("the body isn't JSON: %v", err)
+		return cc
+	}
+	cc.Problems = append(cc.Problems, doc.validate(mt.Schema, v, false)...)
+	return cc
+}
+
+func (cc *contractCheck) problem(format string, args ...interface{}) {
+	cc.Problems = append(cc.Problems, fmt.Sprintf(format, args...))
+}
+
+// report prints the problems, to stdout for the table display, stderr otherwise.
+func (cc *contractCheck) report(table bool) {
+	name := cc.Operation
+	if name == "" {
+		name = cc.Method + " " + cc.Path
+	}
+	if len(cc.Problems) == 0 {
+		if table && config.Verbose() {
+			fmt.Printf("%s\n", t.Success("The response matches the spec for %s.", name))
+		}
+		return
+	}
+
+	var w io.Writer = os.Stdout
+	if !table {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, "%s\n", t.Warn("The response doesn't match the spec for %s:", name))
+	for _, p := range cc.Problems {
+		fmt.Fprintf(w, "  %s\n", t.Text("%s", p))
+	}
+	if strictFlag {
+		exitCode = contractExitCode
+	}
+}
+
+// servicePath is the request's path without the path in the serviceURL.
+func servicePath(conn *connection.Connection, u *url.URL) string {
+	p := u.Path
+	if su, err := url.Parse(conn.ServiceURL); err == nil {
+		p = strings.TrimPrefix(p, strings.TrimSuffix(su.Path, "/"))
+	}
+	if p == "" {
+		p = "/"
+	}
+	return p
+}
+
+// reply is the response in the spec for the status: the status itself, its range (2XX) or default.
+func (op *operation) reply(status int) *openAPIReply {
+	code := strconv.Itoa(status)
+	if r, ok := op.Responses[code]; ok {
+		return r
+	}
+	for k, r := range op.Responses {
+		if strings.EqualFold(k, code[:1]+"XX") {
+			return r
+		}
+	}
+	return op.Responses["default"]
+}
+
+func (op *operation) statuses() []string {
+	codes := []string{}
+	for k := range op.Responses {
+		codes = append(codes, k)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// matchContent finds the media type for a Content-Type, allowing for application/* and */*.
+func matchContent(content map[string]*mediaType, ct string) (*mediaType, string) {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		mt = strings.ToLower(strings.TrimSpace(ct))
+	}
+	for _, k := range []string{mt, strings.SplitN(mt, "/", 2)[0] + "/*", "*/*"} {
+		for ck, m := range content {
+			if ckt, _, err := mime.ParseMediaType(ck); err == nil && ckt == k {
+				return m, ck
+			}
+		}
+	}
+	return nil, ""
+}
+
+func contentTypes(content map[string]*mediaType) []string {
+	cts := []string{}
+	for k := range content {
+		cts = append(cts, k)
+	}
+	sort.Strings(cts)
+	return cts
+}
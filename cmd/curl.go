@@ -0,0 +1,599 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	connection "github.com/jdrivas/conman"
+	t "github.com/jdrivas/termtext"
+	config "github.com/jdrivas/vconfig"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+/*
+Curl Command Lines
+
+http curl sends a curl command line, the kind you get from a browser's
+"Copy as cURL" or someone's bug report, the way the http commands would:
+
+	gafw http curl curl -X POST https://api.example.com/users -H 'Content-Type: application/json' -d '{"name": "amy"}'
+
+The leading curl is optional, but our flags go before it and curl's after it,
+since curl's flags aren't ours (-d is data to curl, debug to us). Without it
+everything is curl's:
+
+	gafw http curl -o json curl https://api.example.com/users
+
+In interactive mode a line that starts with curl is taken as http curl, and a
+pasted command that goes over more than one line with \ is read all together.
+
+We understand -X, -H, -d (--data, --data-raw, --data-binary, --data-urlencode, --json),
+-u, -F, -k, -G, -I, -b, -A, -e and --compressed, which we do anyway. Flags that only
+change what curl prints (-s, -v, -o ...) are skipped. Anything else is an error,
+rather than sending something other than what the command would.
+
+When the URL's host is a connection's, the request is sent as that connection's, the current
+connection first: its headers (where the command doesn't have them), auth and TLS settings
+apply, and the response is checked against its spec.
+*/
+
+func buildCurl(mode runMode) {
+	httpCmd.AddCommand(&cobra.Command{
+		Use:   "curl [flags] [curl] <curl options and URL>",
+		Short: "Send a curl command line.",
+		Long: `Send the request a curl command line describes, using the connection for the URL's host
+if there is one. Put our flags before the word curl, curl's go after it.`,
+		Example:            fmt.Sprintf("  %s http curl -q data curl -H 'Accept: application/json' https://api.example.com/users", config.AppName),
+		DisableFlagParsing: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			ours, theirs := splitCurlArgs(args)
+			cr, err := parseCurl(theirs)
+			if err != nil {
+				fmt.Printf("%s\n", t.Error(err))
+				return
+			}
+			if cr.Insecure {
+				ours = append(ours, "--"+insecureFlagKey)
+			}
+			applyUsualFlags(ours)
+			sendCurl(cr)
+		},
+	})
+}
+
+// With flag parsing off, the usual flags come to http curl along with the rest.
+// Set them up the way doCobraOnInit and rootPre would have.
+func applyUsualFlags(args []string) {
+	fs := pflag.NewFlagSet("usual", pflag.ContinueOnError)
+	fs.ParseErrorsWhitelist.UnknownFlags = true
+	fs.SetOutput(ioutil.Discard)
+	fs.AddFlagSet(rootCmd.PersistentFlags())
+	fs.AddFlagSet(httpCmd.PersistentFlags())
+	fs.Parse(args)
+	if fs.Changed(configFlagKey) {
+		config.InitConfig()
+	}
+	config.ApplyFromFlags(rootCmd.PersistentFlags())
+	moduleInit()
+}
+
+// splitCurlArgs splits our flags from curl's at the word curl.
+func splitCurlArgs(args []string) (ours, theirs []string) {
+	for i, a := range args {
+		if a == "curl" {
+			return args[:i], args[i+1:]
+		}
+	}
+	return nil, args
+}
+
+// isCurlLine is true for a line that's a curl command.
+func isCurlLine(line string) bool {
+	fs := strings.Fields(line)
+	return len(fs) > 0 && fs[0] == "curl"
+}
+
+// Parsing
+//
+
+type curlRequest struct {
+	Method   string
+	URL      string
+	Header   http.Header
+	Data     []string // Joined with &.
+	Form     []string // -F name=value
+	Get      bool     // -G puts the data in the query.
+	Head     bool
+	Insecure bool
+	User     string
+}
+
+// curl options we understand that don't take a value.
+var curlSwitches = map[string]string{
+	"-k": "--insecure", "-G": "--get", "-I": "--head",
+	"--insecure": "--insecure", "--get": "--get", "--head": "--head", "--compressed": "--compressed",
+}
+
+// curl options that don't change the request, by whether they take a value.
+var curlIgnored = map[string]bool{
+	"-s": false, "--silent": false, "-S": false, "--show-error": false, "-L": false, "--location": false,
+	"-i": false, "--include": false, "-v": false, "--verbose": false, "-g": false, "--globoff": false,
+	"-f": false, "--fail": false, "--fail-with-body": false, "-N": false, "--no-buffer": false,
+	"-#": false, "--progress-bar": false, "--no-progress-meter": false, "--http1.1": false, "--http2": false,
+	"-o": true, "--output": true, "-m": true, "--max-time": true, "--connect-timeout": true,
+	"-w": true, "--write-out": true, "--retry": true, "-c": true, "--cookie-jar": true, "--max-redirs": true,
+}
+
+// curl options with a value, short ones to their long names.
+var curlValues = map[string]string{
+	"-X": "--request", "-H": "--header", "-d": "--data", "-u": "--user", "-F": "--form",
+	"-b": "--cookie", "-A": "--user-agent", "-e": "--referer",
+	"--request": "--request", "--header": "--header", "--data": "--data", "--data-ascii": "--data",
+	"--data-raw": "--data-raw", "--data-binary": "--data-binary", "--data-urlencode": "--data-urlencode",
+	"--json": "--json", "--user": "--user", "--form": "--form", "--cookie": "--cookie",
+	"--user-agent": "--user-agent", "--referer": "--referer", "--url": "--url",
+}
+
+func parseCurl(args []string) (*curlRequest, error) {
+	cr := &curlRequest{Header: http.Header{}}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			if cr.URL != "" {
+				return nil, fmt.Errorf("there's more than one URL: %s and %s", cr.URL, arg)
+			}
+			cr.URL = arg
+			continue
+		}
+
+		// Split out --opt=value, -Xvalue and -sSL.
+		opt, value, hasValue := arg, "", false
+		if strings.HasPrefix(arg, "--") {
+			if i := strings.Index(arg, "="); i > 0 {
+				opt, value, hasValue = arg[:i], arg[i+1:], true
+			}
+		} else if len(arg) > 2 {
+			opt = arg[:2]
+			if _, ok := curlValues[opt]; ok || curlIgnored[opt] {
+				value, hasValue = arg[2:], true
+			} else {
+				rest := []string{}
+				for _, c := range arg[1:] {
+					rest = append(rest, "-"+string(c))
+				}
+				args = append(append(append([]string{}, args[:i+1]...), rest...), args[i+1:]...)
+				continue
+			}
+		}
+
+		if name, ok := curlSwitches[opt]; ok {
+			switch name {
+			case "--insecure":
+				cr.Insecure = true
+			case "--get":
+				cr.Get = true
+			case "--head":
+				cr.Head = true
+			}
+			continue
+		}
+		if takesValue, ok := curlIgnored[opt]; ok {
+			if takesValue && !hasValue {
+				i++
+			}
+			continue
+		}
+		name, ok := curlValues[opt]
+		if !ok {
+			return nil, fmt.Errorf("curl option %s isn't one we can send", opt)
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("curl option %s needs a value", opt)
+			}
+			i++
+			value = args[i]
+		}
+		if err := cr.set(name, value); err != nil {
+			return nil, fmt.Errorf("%s: %v", opt, err)
+		}
+	}
+	if cr.URL == "" {
+		return nil, errors.New("there's no URL")
+	}
+	if !strings.Contains(cr.URL, "://") {
+		cr.URL = "http://" + cr.URL // As curl does.
+	}
+	return cr, nil
+}
+
+func (cr *curlRequest) set(name, value string) error {
+	switch name {
+	case "--request":
+		cr.Method = strings.ToUpper(value)
+	case "--header":
+		i := strings.Index(value, ":")
+		if i < 0 {
+			return fmt.Errorf("header %q isn't Name: value", value)
+		}
+		cr.Header.Add(strings.TrimSpace(value[:i]), strings.TrimSpace(value[i+1:]))
+	case "--data":
+		b, err := curlData(value, true)
+		if err != nil {
+			return err
+		}
+		cr.Data = append(cr.Data, b)
+	case "--data-binary":
+		b, err := curlData(value, false)
+		if err != nil {
+			return err
+		}
+		cr.Data = append(cr.Data, b)
+	case "--data-raw":
+		cr.Data = append(cr.Data, value)
+	case "--data-urlencode":
+		d, err := curlURLEncode(value)
+		if err != nil {
+			return err
+		}
+		cr.Data = append(cr.Data, d)
+	case "--json":
+		b, err := curlData(value, false)
+		if err != nil {
+			return err
+		}
+		cr.Data = append(cr.Data, b)
+		if cr.Header.Get("Content-Type") == "" {
+			cr.Header.Set("Content-Type", "application/json")
+		}
+		if cr.Header.Get("Accept") == "" {
+			cr.Header.Set("Accept", "application/json")
+		}
+	case "--user":
+		if !strings.Contains(value, ":") {
+			return errors.New("use user:password, we can't ask for the password")
+		}
+		cr.User = value
+	case "--form":
+		if !strings.Contains(value, "=") {
+			return fmt.Errorf("form field %q isn't name=value", value)
+		}
+		cr.Form = append(cr.Form, value)
+	case "--cookie":
+		if !strings.Contains(value, "=") {
+			return errors.New("cookie files aren't supported, use name=value")
+		}
+		cr.Header.Add("Cookie", value)
+	case "--user-agent":
+		cr.Header.Set("User-Agent", value)
+	case "--referer":
+		cr.Header.Set("Referer", value)
+	case "--url":
+		cr.URL = value
+	}
+	return nil
+}
+
+// curlData reads @file data. -d takes the newlines out of files, --data-binary doesn't.
+func curlData(value string, strip bool) (string, error) {
+	if !strings.HasPrefix(value, "@") {
+		return value, nil
+	}
+	b, err := readCurlFile(value[1:])
+	if err != nil {
+		return "", err
+	}
+	if strip {
+		b = bytes.Replace(bytes.Replace(b, []byte("\r"), nil, -1), []byte("\n"), nil, -1)
+	}
+	return string(b), nil
+}
+
+// curlURLEncode does --data-urlencode's content, =content, name=content, @file and name@file.
+func curlURLEncode(value string) (string, error) {
+	name, content := "", value
+	if i := strings.IndexAny(value, "=@"); i >= 0 {
+		name, content = value[:i], value[i+1:]
+		if value[i] == '@' {
+			b, err := readCurlFile(content)
+			if err != nil {
+				return "", err
+			}
+			content = string(b)
+		}
+	}
+	if name == "" {
+		return curlEscape(content), nil
+	}
+	return name + "=" + curlEscape(content), nil
+}
+
+// curlEscape escapes like curl, %20 for a space rather than +.
+func curlEscape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+func readCurlFile(name string) ([]byte, error) {
+	if name == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(expandHome(name))
+}
+
+// Sending
+//
+
+func sendCurl(cr *curlRequest) {
+	req, err := cr.request()
+	if err != nil {
+		fmt.Printf("%s\n", t.Error(err))
+		return
+	}
+	if conn := curlConnection(req.URL); conn != nil {
+		if config.Verbose() {
+			fmt.Printf("%s\n", t.Info("Sending as connection %s.", conn.Name))
+		}
+		for k, v := range conn.Headers {
+			if req.Header.Get(k) == "" {
+				req.Header.Set(k, v)
+			}
+		}
+		req = withConnection(req, conn)
+	}
+	httpDisplay(sendRequest(req))
+}
+
+func (cr *curlRequest) request() (*http.Request, error) {
+	u, err := url.Parse(cr.URL)
+	if err != nil {
+		return nil, err
+	}
+	method := cr.Method
+	var body io.Reader
+	header := http.Header{}
+	for k, vs := range cr.Header {
+		if !isConnectionHeader(k) { // Accept-Encoding too, so the transport can decompress.
+			header[k] = vs
+		}
+	}
+
+	data := strings.Join(cr.Data, "&")
+	switch {
+	case len(cr.Form) > 0 && len(cr.Data) > 0:
+		return nil, errors.New("use -F or -d, not both")
+	case len(cr.Form) > 0:
+		b, ct, err := curlMultipart(cr.Form)
+		if err != nil {
+			return nil, err
+		}
+		body = b
+		header.Set("Content-Type", ct)
+		method = defaultString(method, http.MethodPost)
+	case cr.Get && len(cr.Data) > 0:
+		if u.RawQuery != "" {
+			data = u.RawQuery + "&" + data
+		}
+		u.RawQuery = data
+	case len(cr.Data) > 0:
+		body = strings.NewReader(data)
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		method = defaultString(method, http.MethodPost)
+	}
+	if cr.Head {
+		method = defaultString(method, http.MethodHead)
+	}
+	method = defaultString(method, http.MethodGet)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	if cr.User != "" {
+		up := strings.SplitN(cr.User, ":", 2)
+		req.SetBasicAuth(up[0], up[1])
+	}
+	return req, nil
+}
+
+// curlMultipart builds -F fields: name=value, name=@file (an upload, with ;type= and
+// ;filename=) and name=<file (the file's contents as the value).
+func curlMultipart(fields []string) (io.Reader, string, error) {
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+	for _, f := range fields {
+		i := strings.Index(f, "=")
+		name, value := f[:i], f[i+1:]
+		switch {
+		case strings.HasPrefix(value, "@"):
+			parts := strings.Split(value[1:], ";")
+			content, err := readCurlFile(parts[0])
+			if err != nil {
+				return nil, "", err
+			}
+			filename, ct := filepath.Base(parts[0]), "application/octet-stream"
+			for _, p := range parts[1:] {
+				switch {
+				case strings.HasPrefix(p, "type="):
+					ct = strings.TrimPrefix(p, "type=")
+				case strings.HasPrefix(p, "filename="):
+					filename = strings.Trim(strings.TrimPrefix(p, "filename="), `"`)
+				}
+			}
+			h := textproto.MIMEHeader{}
+			h.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, name, filename))
+			h.Set("Content-Type", ct)
+			pw, err := w.CreatePart(h)
+			if err != nil {
+				return nil, "", err
+			}
+			pw.Write(content)
+		case strings.HasPrefix(value, "<"):
+			content, err := readCurlFile(value[1:])
+			if err != nil {
+				return nil, "", err
+			}
+			w.WriteField(name, string(content))
+		default:
+			w.WriteField(name, value)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return &b, w.FormDataContentType(), nil
+}
+
+// curlConnection finds the connection for the URL's host, the current one if it's on it,
+// otherwise the one with the longest service URL that the URL starts with, otherwise
+// the first on the host.
+func curlConnection(u *url.URL) *connection.Connection {
+	host := canonicalHost(u)
+	if conn, err := connection.GetCurrentConnection(); err == nil && sameHost(conn, host) {
+		return conn
+	}
+	var found *connection.Connection
+	for _, c := range connection.GetAllConnections() {
+		if !sameHost(c, host) {
+			continue
+		}
+		if found == nil || (servesURL(c, u) && len(c.ServiceURL) > len(found.ServiceURL)) {
+			found = c
+		}
+	}
+	return found
+}
+
+func sameHost(conn *connection.Connection, host string) bool {
+	su, err := url.Parse(conn.ServiceURL)
+	return err == nil && su.Host != "" && canonicalHost(su) == host
+}
+
+// canonicalHost is host:port with the port filled in for the scheme.
+func canonicalHost(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if strings.EqualFold(u.Scheme, "https") {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+func defaultString(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// Shell Words
+//
+
+// shellWords splits a line the way sh would: '...', "..." with \ escapes, bash's $'...',
+// and \ outside of quotes, including at the end of a line to carry on to the next.
+func shellWords(line string) ([]string, error) {
+	words := []string{}
+	var w strings.Builder
+	inWord := false
+	rs := []rune(line)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inWord {
+				words = append(words, w.String())
+				w.Reset()
+				inWord = false
+			}
+		case r == '\\':
+			if i+1 < len(rs) {
+				i++
+				if rs[i] == '\n' {
+					continue
+				}
+				w.WriteRune(rs[i])
+			}
+			inWord = true
+		case r == '\'':
+			inWord = true
+			j := i + 1
+			for j < len(rs) && rs[j] != '\'' {
+				j++
+			}
+			if j >= len(rs) {
+				return nil, errors.New("there's a ' without an end")
+			}
+			w.WriteString(string(rs[i+1 : j]))
+			i = j
+		case r == '"':
+			inWord = true
+			j := i + 1
+			for ; j < len(rs) && rs[j] != '"'; j++ {
+				if rs[j] == '\\' && j+1 < len(rs) && strings.ContainsRune("\"\\$`\n", rs[j+1]) {
+					j++
+					if rs[j] != '\n' {
+						w.WriteRune(rs[j])
+					}
+					continue
+				}
+				w.WriteRune(rs[j])
+			}
+			if j >= len(rs) {
+				return nil, errors.New(`there's a " without an end`)
+			}
+			i = j
+		case r == '$' && i+1 < len(rs) && rs[i+1] == '\'':
+			inWord = true
+			j := i + 2
+			for ; j < len(rs) && rs[j] != '\''; j++ {
+				if rs[j] == '\\' && j+1 < len(rs) {
+					j++
+					w.WriteString(ansiCEscape(rs[j]))
+					continue
+				}
+				w.WriteRune(rs[j])
+			}
+			if j >= len(rs) {
+				return nil, errors.New("there's a $' without an end")
+			}
+			i = j
+		default:
+			inWord = true
+			w.WriteRune(r)
+		}
+	}
+	if inWord {
+		words = append(words, w.String())
+	}
+	return words, nil
+}
+
+// ansiCEscape is the escaped character in a $'...' string.
+func ansiCEscape(r rune) string {
+	switch r {
+	case 'n':
+		return "\n"
+	case 't':
+		return "\t"
+	case 'r':
+		return "\r"
+	default:
+		return string(r) // \\, \', \" and the rest.
+	}
+}
@@ -1,7 +1,10 @@
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -18,17 +21,100 @@ import (
 
 // Shim for t.httpDisplay, with timing display.
 func httpDisplay(se *conman.SideEffect, resp *http.Response, err error) {
-	if !viper.GetBool(t.JSONDisplayKey) {
-		if se.ElapsedTime.Milliseconds() < 1000 {
-			fmt.Printf(t.Title("Command took %d milliseconds\n", se.ElapsedTime.Milliseconds()))
-		} else {
-			fmt.Printf(t.Title("Command took %4g seconds\n", se.ElapsedTime.Seconds()))
+	if errors.Is(err, errDryRun) {
+		fmt.Printf("%s\n", t.Warn("Dry run: request not sent."))
+		return
+	}
+	if err != nil && strictFlag {
+		exitCode = requestExitCode
+	}
+	if resp != nil { // Before the query changes the body.
+		history.add(resp)
+		saveFromResponse(recent.keep(resp), err)
+	}
+	ex := responseExchange(resp)
+	format, tmpl := outputFormat()
+	if rawFlag {
+		format = rawOutput
+	}
+	if ex != nil {
+		if cc := checkContract(resp, ex.Connection); cc != nil { // Before the query changes the body.
+			defer cc.report(format == tableOutput)
+		}
+	}
+	if queryFlag != "" && resp != nil && err == nil { // Error bodies go out as they are.
+		if qerr := applyQuery(resp, queryFlag); qerr != nil {
+			fmt.Printf("%s\n", t.Error(qerr))
+			return
+		}
+	}
+	if format != tableOutput {
+		httpOutput(format, tmpl, resp, err, ex)
+		return
+	}
+	if se.ElapsedTime.Milliseconds() < 1000 {
+		fmt.Printf(t.Title("Command took %d milliseconds\n", se.ElapsedTime.Milliseconds()))
+	} else {
+		fmt.Printf(t.Title("Command took %4g seconds\n", se.ElapsedTime.Seconds()))
 
+	}
+	if timingFlag && ex != nil {
+		displayTiming(ex.Timing.phases())
+	}
+	if ex != nil && len(ex.Signatures) > 0 {
+		displaySignatureChecks(ex.Signatures)
+	}
+	if resp != nil && err == nil {
+		if tbl := responseTable(resp); tbl != nil {
+			t.List(tbl, resp, err)
+			return
 		}
 	}
 	t.HTTPDisplay(resp, err)
 }
 
+// httpOutput prints the response body in one of the non-table formats.
+// With --timing the body goes out along with the status and the timing.
+func httpOutput(format, tmpl string, resp *http.Response, err error, ex *exchange) {
+	if resp == nil {
+		fmt.Printf("%s\n", t.Error(err))
+		return
+	}
+	body, rerr := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if rerr != nil {
+		fmt.Printf("Body read error: %v\n", rerr)
+		return
+	}
+
+	var v interface{}
+	switch {
+	case json.Valid(body):
+		v = json.RawMessage(body)
+	case len(body) > 0:
+		v = string(body)
+	}
+	timed := timingFlag && ex != nil
+	if timed {
+		v = timedResponse{Status: resp.StatusCode, Timing: ex.Timing.phases().json(), Body: v}
+	}
+
+	switch {
+	case format == rawOutput && !timed && queryFlag == "":
+		os.Stdout.Write(body)
+	case format == jsonOutput && !timed && v != nil && !json.Valid(body):
+		fmt.Printf("%s", body)
+	case v == nil:
+		if err != nil { // Nothing else to tell them what happened.
+			fmt.Printf("%s\n", t.Error(err))
+		}
+	default:
+		if err := writeOutput(format, tmpl, v); err != nil {
+			fmt.Printf("%s\n", t.Error(err))
+		}
+	}
+}
+
 // Configuration
 
 func printConfig() {
@@ -112,6 +198,23 @@ func printFlagSet(flags *pflag.FlagSet) {
 	w.Flush()
 }
 
+// flagView is a flag for the non-table output formats.
+type flagView struct {
+	Name      string `json:"name"`
+	Shorthand string `json:"shorthand,omitempty"`
+	Value     string `json:"value"`
+	Type      string `json:"type"`
+	Default   string `json:"default"`
+	Changed   bool   `json:"changed"`
+}
+
+func flagViews(flags *pflag.FlagSet) (fvs []flagView) {
+	flags.VisitAll(func(f *pflag.Flag) {
+		fvs = append(fvs, flagView{f.Name, f.Shorthand, f.Value.String(), f.Value.Type(), f.DefValue, f.Changed})
+	})
+	return fvs
+}
+
 func flagHeader() string {
 	return t.Title("Name\tShort\tValue\tType\tDefValue\tChanged")
 }
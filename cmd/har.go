@@ -0,0 +1,469 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	connection "github.com/jdrivas/conman"
+	"github.com/jdrivas/gafw/version"
+	t "github.com/jdrivas/termtext"
+	config "github.com/jdrivas/vconfig"
+	"github.com/juju/ansiterm"
+	"github.com/spf13/cobra"
+)
+
+/*
+HAR Files
+
+HTTP Archive 1.2 (http://www.softwareishard.com/blog/har-12-spec/), the format
+browsers' dev tools save network traffic in. We write HARs from the same
+interactions that go into cassettes (see cassette.go), so they have the same
+redaction. The connection goes in _connection, HAR's way of adding fields.
+
+export har <file> writes the session history (see history.go), with the timing
+phases the transport measured for each request. serve proxy --save writes them too.
+
+import har <file> lists the entries. With entry numbers (1 3-5, or all) it sends
+those entries to the current connection instead: the path and query are kept and
+put on the connection's service URL. Headers go along, except the ones that were
+redacted, the ones that are about the connection (Host, Content-Length ...) and
+HTTP/2's pseudo headers. The connection's own headers and auth are added as usual.
+*/
+
+const harVersion = "1.2"
+
+func buildHAR(mode runMode) {
+	exportCmd.AddCommand(&cobra.Command{
+		Use:     "har <file>",
+		Short:   "Write the requests sent this session to a HAR file.",
+		Long:    "Write the requests sent this session, with their responses and timing, to an HTTP Archive (HAR 1.2) file. Credentials are redacted.",
+		Example: fmt.Sprintf("  %s export har session.har", config.AppName),
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			sent := history.all()
+			entries := []*harEntry{}
+			for _, x := range sent {
+				entries = append(entries, x.harEntry())
+			}
+			if err := writeHARFile(expandHome(args[0]), newHARFile(entries)); err != nil {
+				fmt.Printf("%s\n", t.Error(err))
+				return
+			}
+			fmt.Printf("%s %s\n", t.Title("%d requests written to", len(entries)), t.Highlight("%s", args[0]))
+		},
+	})
+
+	importCmd.AddCommand(&cobra.Command{
+		Use:   "har <file> [<entry> ...]",
+		Short: "List the entries in a HAR file, or send them to the current connection.",
+		Long: `With just the file, list the entries in an HTTP Archive (HAR) file.
+With entry numbers, ranges (3-5) or all, send those entries to the current connection,
+keeping the path, query, headers and body.`,
+		Example: fmt.Sprintf("  %s import har session.har\n  %s import har session.har 1 3-5", config.AppName, config.AppName),
+		Args:    cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			h, err := readHAR(expandHome(args[0]))
+			if err != nil {
+				fmt.Printf("%s\n", t.Error(err))
+				return
+			}
+			if len(args) == 1 {
+				list := h.list()
+				printOutput(list, func() { displayHAREntries(list) })
+				return
+			}
+			picked, err := pickEntries(args[1:], len(h.Log.Entries))
+			if err != nil {
+				fmt.Printf("%s\n", t.Error(err))
+				return
+			}
+			conn, err := connection.GetCurrentConnection()
+			if err != nil {
+				fmt.Printf("%s\n", t.Error(err))
+				return
+			}
+			for _, i := range picked {
+				e := h.Log.Entries[i]
+				req, err := e.request(conn)
+				if err != nil {
+					fmt.Printf("%s\n", t.Error(fmt.Errorf("entry %d: %v", i+1, err)))
+					continue
+				}
+				if format, _ := outputFormat(); format == tableOutput {
+					fmt.Printf("%s\n", t.Title("%d: %s %s", i+1, req.Method, req.URL.RequestURI()))
+				}
+				httpDisplay(sendRequest(req))
+			}
+		},
+	})
+}
+
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string      `json:"version"`
+	Creator harCreator  `json:"creator"`
+	Entries []*harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"` // ms
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Connection      string      `json:"_connection,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string         `json:"mimeType"`
+	Text     string         `json:"text"`
+	Params   []harNameValue `json:"params,omitempty"`
+	Encoding string         `json:"_encoding,omitempty"` // HAR post data is text, so we note base64.
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// Times are in ms, -1 when it doesn't apply (or we don't know).
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	SSL     float64 `json:"ssl"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func newHARFile(entries []*harEntry) *harFile {
+	v := version.Version
+	return &harFile{Log: harLog{
+		Version: harVersion,
+		Creator: harCreator{Name: config.AppName, Version: fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Dot)},
+		Entries: entries,
+	}}
+}
+
+// writeHAR writes the interactions to the file as a HAR.
+func writeHAR(path string, ins []*interaction) error {
+	entries := []*harEntry{}
+	for _, in := range ins {
+		entries = append(entries, harEntryFor(in))
+	}
+	return writeHARFile(path, newHARFile(entries))
+}
+
+func writeHARFile(path string, h *harFile) error {
+	b, err := json.MarshalIndent(h, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(path, append(b, '\n'), 0600)
+	}
+	if err != nil {
+		return fmt.Errorf("saving HAR %s: %v", path, err)
+	}
+	return nil
+}
+
+// harEntryFor converts a cassette interaction. All we know about the time is the total,
+// so it's all waiting.
+func harEntryFor(in *interaction) *harEntry {
+	e := &harEntry{
+		StartedDateTime: in.Recorded,
+		Time:            in.Millis,
+		Connection:      in.Connection,
+		Timings:         harTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Wait: in.Millis},
+	}
+
+	req := in.Request
+	e.Request = harRequest{
+		Method:      req.Method,
+		URL:         req.URL,
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(req.Headers),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+	}
+	if u, err := url.Parse(req.URL); err == nil {
+		q := u.Query()
+		for _, k := range sortedValueKeys(q) {
+			for _, v := range q[k] {
+				e.Request.QueryString = append(e.Request.QueryString, harNameValue{Name: k, Value: v})
+			}
+		}
+	}
+	if body, err := decodeBody(req.Body, req.Encoding); err == nil && len(body) > 0 {
+		e.Request.BodySize = len(body)
+		e.Request.PostData = &harPostData{MimeType: req.Headers.Get("Content-Type"), Text: req.Body, Encoding: req.Encoding}
+	}
+
+	resp := in.Response
+	e.Response = harResponse{
+		Status:      resp.Status,
+		StatusText:  http.StatusText(resp.Status),
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(resp.Headers),
+		Content:     harContent{MimeType: resp.Headers.Get("Content-Type"), Text: resp.Body, Encoding: resp.Encoding},
+		RedirectURL: resp.Headers.Get("Location"),
+		HeadersSize: -1,
+	}
+	if body, err := decodeBody(resp.Body, resp.Encoding); err == nil {
+		e.Response.Content.Size = len(body)
+		e.Response.BodySize = len(body)
+	}
+	return e
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	nvs := []harNameValue{}
+	for _, k := range sortedValueKeys(url.Values(h)) {
+		for _, v := range h[k] {
+			nvs = append(nvs, harNameValue{Name: k, Value: v})
+		}
+	}
+	return nvs
+}
+
+func sortedValueKeys(m url.Values) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// harEntry is the sent exchange, redacted, with the measured timing.
+func (x *sentExchange) harEntry() *harEntry {
+	e := harEntryFor(x.interaction.redacted())
+	e.Time, e.Timings = harTimingsFor(x.Phases)
+	return e
+}
+
+// harTimingsFor fits our phases into HAR's. Connect includes the TLS handshake in HAR,
+// and send is what's left over, so the phases add up to the total.
+func harTimingsFor(p timingPhases) (float64, harTimings) {
+	ht := harTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Wait: ms(p.Server), Receive: ms(p.Transfer)}
+	known := ht.Wait + ht.Receive
+	if p.DNS > 0 {
+		ht.DNS = ms(p.DNS)
+		known += ht.DNS
+	}
+	if p.Connect > 0 || p.TLS > 0 {
+		ht.Connect = ms(p.Connect + p.TLS)
+		known += ht.Connect
+	}
+	if p.TLS > 0 {
+		ht.SSL = ms(p.TLS)
+	}
+	total := ms(p.Total)
+	if total > known {
+		ht.Send = total - known
+	}
+	return total, ht
+}
+
+// Importing
+//
+
+func readHAR(path string) (*harFile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	h := &harFile{}
+	if err := json.Unmarshal(b, h); err != nil {
+		return nil, fmt.Errorf("HAR %s: %v", path, err)
+	}
+	return h, nil
+}
+
+type harListEntry struct {
+	Entry   int     `json:"entry"`
+	Started string  `json:"started"`
+	Method  string  `json:"method"`
+	URL     string  `json:"url"`
+	Status  int     `json:"status"`
+	Time    float64 `json:"time"`
+}
+
+func (h *harFile) list() []harListEntry {
+	list := []harListEntry{}
+	for i, e := range h.Log.Entries {
+		list = append(list, harListEntry{
+			Entry:   i + 1,
+			Started: e.StartedDateTime.Local().Format("2006-01-02 15:04:05"),
+			Method:  e.Request.Method,
+			URL:     e.Request.URL,
+			Status:  e.Response.Status,
+			Time:    e.Time,
+		})
+	}
+	return list
+}
+
+func displayHAREntries(list []harListEntry) {
+	if len(list) == 0 {
+		fmt.Printf("%s\n", t.Title("There aren't any entries."))
+		return
+	}
+	w := ansiterm.NewTabWriter(os.Stdout, 4, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\n", t.Title("Entry\tStarted\tMethod\tURL\tStatus\tTime"))
+	for _, e := range list {
+		fmt.Fprintf(w, "%s\n", t.Text("%d\t%s\t%s\t%s\t%d\t%.1fms", e.Entry, e.Started, e.Method, e.URL, e.Status, e.Time))
+	}
+	w.Flush()
+}
+
+// pickEntries turns entry numbers, ranges and all into indexes.
+func pickEntries(args []string, n int) ([]int, error) {
+	picked := []int{}
+	for _, arg := range strings.Split(strings.Join(args, ","), ",") {
+		arg = strings.TrimSpace(arg)
+		if arg == "" {
+			continue
+		}
+		if strings.EqualFold(arg, "all") {
+			for i := 0; i < n; i++ {
+				picked = append(picked, i)
+			}
+			continue
+		}
+		from, to := arg, arg
+		if i := strings.Index(arg, "-"); i > 0 {
+			from, to = arg[:i], arg[i+1:]
+		}
+		f, ferr := strconv.Atoi(from)
+		l, lerr := strconv.Atoi(to)
+		if ferr != nil || lerr != nil || f < 1 || l < f {
+			return nil, fmt.Errorf("%q isn't an entry number or a range like 3-5", arg)
+		}
+		if l > n {
+			return nil, fmt.Errorf("there are only %d entries", n)
+		}
+		for i := f; i <= l; i++ {
+			picked = append(picked, i-1)
+		}
+	}
+	return picked, nil
+}
+
+// Headers that are about the original connection, not the request.
+var connectionHeaders = []string{"Host", "Content-Length", "Connection", "Keep-Alive", "Transfer-Encoding",
+	"Upgrade", "Te", "Trailer", "Proxy-Connection", "Accept-Encoding"}
+
+// request is the entry's request on the connection.
+func (e *harEntry) request(conn *connection.Connection) (*http.Request, error) {
+	u, err := url.Parse(e.Request.URL)
+	if err != nil {
+		return nil, err
+	}
+	path := servicePath(conn, u)
+	if u.RawQuery != "" {
+		raw, q := u.RawQuery, u.Query()
+		if strings.Contains(raw, redacted) { // Leave them out, like the headers.
+			for k, vs := range q {
+				if len(vs) == 1 && vs[0] == redacted {
+					delete(q, k)
+				}
+			}
+			raw = q.Encode()
+		}
+		if raw != "" {
+			path += "?" + raw
+		}
+	}
+
+	var body []byte
+	if pd := e.Request.PostData; pd != nil {
+		if pd.Text == "" && len(pd.Params) > 0 {
+			form := url.Values{}
+			for _, p := range pd.Params {
+				form.Add(p.Name, p.Value)
+			}
+			pd.Text = form.Encode()
+		}
+		if body, err = decodeBody(pd.Text, pd.Encoding); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(e.Request.Method, strings.TrimSuffix(conn.ServiceURL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range e.Request.Headers {
+		if h.Value == redacted || strings.HasPrefix(h.Name, ":") || isConnectionHeader(h.Name) {
+			continue
+		}
+		req.Header.Add(h.Name, h.Value)
+	}
+	if pd := e.Request.PostData; pd != nil && pd.MimeType != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", pd.MimeType)
+	}
+	for k, v := range conn.Headers {
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+func isConnectionHeader(name string) bool {
+	for _, s := range connectionHeaders {
+		if strings.EqualFold(name, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"net/http"
+	"sync"
+)
+
+/*
+Session History
+
+httpDisplay keeps the exchanges it shows this session, the last historySize of them,
+for export har, http export and save request. They're the requests as the transport
+sent them (or replayed them from a cassette), credentials and all, so they have to be
+redacted on the way out. What bench, scenarios and the proxy send isn't kept.
+*/
+
+const historySize = 500
+
+type sentExchange struct {
+	*interaction
+	Phases timingPhases
+}
+
+type sessionHistory struct {
+	mu        sync.Mutex
+	exchanges []*sentExchange
+}
+
+var history = &sessionHistory{}
+
+// add keeps the exchange for a response that came through the transport.
+func (h *sessionHistory) add(resp *http.Response) {
+	ex := responseExchange(resp)
+	if ex == nil {
+		return
+	}
+	body, _ := responseBody(resp)
+	x := &sentExchange{interaction: captureInteraction(ex, resp.Request, ex.requestBody, resp, body), Phases: ex.Timing.phases()}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.exchanges = append(h.exchanges, x)
+	if len(h.exchanges) > historySize {
+		h.exchanges = h.exchanges[len(h.exchanges)-historySize:]
+	}
+}
+
+// all is the history, oldest first.
+func (h *sessionHistory) all() []*sentExchange {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*sentExchange{}, h.exchanges...)
+}
@@ -2,18 +2,24 @@ package cmd
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"strings"
+	"time"
 
 	connection "github.com/jdrivas/conman"
+	t "github.com/jdrivas/termtext"
 	config "github.com/jdrivas/vconfig"
 	"github.com/spf13/cobra"
 )
 
 func buildHTTP(mode runMode) {
+	initHTTPFlags()
+
 	// HTTP Util
 	// TODO: Consider validating the HTTP verbs.
 	httpCmd.AddCommand(&cobra.Command{
-		Use:                   "send [flags] <method> <command> [<json-string> ....]",
+		Use:                   "send [flags] <method> <command> [<json-string> .... | @<file>]",
 		DisableFlagsInUseLine: true,
 		Aliases:               []string{"SEND"},
 		Short:                 "HTTP <method> <command> to the service.",
@@ -22,15 +28,16 @@ func buildHTTP(mode runMode) {
 
 All of the args following <command> are caputred as a single json 
 string and placed in the body of the request, 
-with the ContentType header set to application/json.`,
+with the ContentType header set to application/json.
+Use @<file> to send the contents of a file as the body instead.`,
 		Example: fmt.Sprintf(" %s http send post /groups/test/users {\"name\": \"admin\", \"users\": [\"david\"]}", config.AppName),
 		Args:    cobra.MinimumNArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
 			if conn, err := connection.GetCurrentConnection(); err == nil {
-				if len(args) == 2 {
-					httpDisplay(conn.Send(strings.ToUpper(args[0]), args[1], nil, nil))
+				if body, err := httpBody(args[2:]); err == nil {
+					httpDisplay(conn.Send(strings.ToUpper(args[0]), args[1], body, nil))
 				} else {
-					httpDisplay(conn.Send(strings.ToUpper(args[0]), args[1], strings.Join(args[2:], " "), nil))
+					fmt.Printf("%s\n", t.Error(err))
 				}
 			}
 		},
@@ -52,7 +59,7 @@ with the ContentType header set to application/json.`,
 	})
 
 	httpCmd.AddCommand(&cobra.Command{
-		Use:                   "post [flags] <command> [<json-string> ....]",
+		Use:                   "post [flags] <command> [<json-string> .... | @<file>]",
 		Aliases:               []string{"POST"},
 		DisableFlagsInUseLine: true,
 		Short:                 "HTTP POST <command> <body> to service.",
@@ -60,36 +67,122 @@ with the ContentType header set to application/json.`,
 
 All of the args follwing <command> are caputred as a single json 
 string and placed in the body of the request, 
-with the ContentType header set to application/json.`,
+with the ContentType header set to application/json.
+Use @<file> to send the contents of a file as the body instead.`,
 		Example: fmt.Sprintf("%s http post /groups/test/users {\"name\": \"admin\", \"users\": [\"david\"]}", config.AppName),
 		Args:    cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			if conn, err := connection.GetCurrentConnection(); err == nil {
-				if len(args) > 1 {
-					httpDisplay(conn.Post(args[0], strings.Join(args[1:], " "), nil))
+				if body, err := httpBody(args[1:]); err == nil {
+					httpDisplay(conn.Post(args[0], body, nil))
 				} else {
-					httpDisplay(conn.Post(args[0], nil, nil))
+					fmt.Printf("%s\n", t.Error(err))
 				}
 			}
 		},
 	})
 
 	httpCmd.AddCommand(&cobra.Command{
-		Use:     "delete [flags] <command> [<json-string> ....]",
+		Use:     "delete [flags] <command> [<json-string> .... | @<file>]",
 		Aliases: []string{"DELETE"},
 		Short:   "HTTP DELETE <command> <body> to service.",
 		Long: `Sends an HTTP DELETE <command> <body>to the service endpoint.  
 
 All of the args following <command> are caputred as a single json 
 string and placed in the body of the request, 
-with the ContentType header set to application/json.`,
+with the ContentType header set to application/json.
+Use @<file> to send the contents of a file as the body instead.`,
 		Example: fmt.Sprintf("%s http delete /groups/test/users {\"name\": \"admin\", \"users\": [\"david\"]}", config.AppName),
 		Args:    cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			if conn, err := connection.GetCurrentConnection(); err == nil {
-				httpDisplay(conn.Delete(args[0], nil, nil))
+				if body, err := httpBody(args[1:]); err == nil {
+					httpDisplay(conn.Delete(args[0], body, nil))
+				} else {
+					fmt.Printf("%s\n", t.Error(err))
+				}
 			}
 		},
 	})
 
 }
+
+// httpBody captures the args as a single json string for the request body.
+// A single @<file> argument reads the body from the file instead.
+// No args, no body.
+func httpBody(args []string) (body interface{}, err error) {
+	switch {
+	case len(args) == 0:
+		return nil, nil
+	case len(args) == 1 && strings.HasPrefix(args[0], "@"):
+		b, err := ioutil.ReadFile(args[0][1:])
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	default:
+		return strings.Join(args, " "), nil
+	}
+}
+
+// sendRequest sends a request we built ourselves, for when Send's JSON body won't do.
+// It answers like Send, so the results can go to httpDisplay.
+func sendRequest(req *http.Request) (*connection.SideEffect, *http.Response, error) {
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	se := &connection.SideEffect{ElapsedTime: time.Since(start)}
+	if err == nil && resp.StatusCode >= 300 {
+		err = fmt.Errorf("HTTP Request %s:%s, HTTP Response: %s.", req.Method, req.URL, resp.Status)
+	}
+	return se, resp, err
+}
+
+// HTTP command flags
+//
+
+var (
+	dryRunFlag  bool
+	timingFlag  bool
+	queryFlag   string
+	rawFlag     bool
+	columnsFlag []string
+	sortFlag    string
+	wideFlag    bool
+	strictFlag  bool
+)
+
+const (
+	dryRunFlagKey  = "dry-run"
+	timingFlagKey  = "timing"
+	queryFlagKey   = "query"
+	rawFlagKey     = "raw"
+	columnsFlagKey = "columns"
+	sortFlagKey    = "sort"
+	wideFlagKey    = "wide"
+	strictFlagKey  = "strict"
+)
+
+// These live on the http command, so they get torn down and
+// rebuilt with the rest of the flags in reset().
+func initHTTPFlags() {
+	httpCmd.PersistentFlags().BoolVar(&dryRunFlag, dryRunFlagKey, false,
+		"Build and sign the request, display it, but don't send it.")
+	httpCmd.PersistentFlags().BoolVar(&timingFlag, timingFlagKey, false,
+		"Show a breakdown of where the request time went (DNS, connect, TLS, server, transfer).")
+	httpCmd.PersistentFlags().StringVarP(&queryFlag, queryFlagKey, "q", "",
+		"JMESPath expression to apply to the JSON response body before display (e.g. 'items[].name').")
+	httpCmd.PersistentFlags().BoolVar(&rawFlag, rawFlagKey, false,
+		"Print strings unquoted and arrays one element per line, for shell scripts.")
+	httpCmd.PersistentFlags().StringSliceVar(&columnsFlag, columnsFlagKey, nil,
+		"Columns for array responses shown as a table, paths are fine (e.g. id,name,address.city).")
+	httpCmd.PersistentFlags().StringVar(&sortFlag, sortFlagKey, "",
+		"Sort array responses shown as a table by this column, - in front for descending.")
+	httpCmd.PersistentFlags().BoolVar(&wideFlag, wideFlagKey, false,
+		"Don't truncate table columns to fit the terminal.")
+	httpCmd.PersistentFlags().BoolVar(&strictFlag, strictFlagKey, false,
+		"Exit non-zero when the response doesn't match the connection's OpenAPI spec.")
+	httpCmd.PersistentFlags().StringArrayVar(&saveFlag, saveFlagKey, nil,
+		"Save a value from the JSON response in a variable, as <name>=<jsonpath> (e.g. id=$.data[0].id).")
+	httpCmd.PersistentFlags().StringArrayVar(&saveHeaderFlag, saveHeaderFlagKey, nil,
+		"Save a response header in a variable, as <name>=<Header-Name>.")
+}
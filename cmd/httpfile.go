@@ -0,0 +1,590 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	connection "github.com/jdrivas/conman"
+	t "github.com/jdrivas/termtext"
+	config "github.com/jdrivas/vconfig"
+	"github.com/spf13/cobra"
+)
+
+/*
+.http Files
+
+run sends the requests in a VS Code REST Client or JetBrains HTTP Client file, the
+.http files that teams keep next to their code:
+
+	@token = abc123
+
+	### List users
+	# @name users
+	GET {{host}}/users?limit=10
+	Authorization: Bearer {{token}}
+
+	###
+	POST /users
+	Content-Type: application/json
+
+	{"name": "amy", "manager": {{users.response.body.$.data[0].id}}}
+
+	> {% client.global.set("id", response.body.id); %}
+
+Requests are separated by ###, whatever follows it is the request's name, or # @name
+gives it one. @name = value sets a file variable. {{name}} is a file variable, a
+global set by a handler, a session variable (see vars.go), or:
+
+	{{host}}, {{baseUrl}}          the connection's service URL (unless the file sets them)
+	{{req.response.body.$.path}}    a value from the named request's JSON response, or
+	{{req.response.body.*}}         all of it
+	{{req.response.headers.Name}}   a header from its response
+	{{$guid}} {{$uuid}}             a random UUID
+	{{$timestamp}}                  Unix time, {{$datetime iso8601}} or rfc1123 for a date
+	{{$randomInt 1 100}}            a random int from 1 up to (not including) 100
+	{{$processEnv NAME}}            an environment variable
+
+A request that names another request that hasn't been sent yet sends that one first.
+
+The requests go to the current connection: a path goes on its service URL, and so does
+the path and query of a full URL. A body can be a file, < ./body.json, or <@ ./body.json
+to fill in its variables. Lines after the request line starting with ? or & carry on its
+query string.
+
+Response handlers (> {% ... %}) are JavaScript we don't run, except for the
+client.global.set("name", value) calls, where value is response.body.path,
+response.headers.valueOf("Name") or a string. Handler files (> ./handler.js) and
+response references (<> ./response.json) are skipped.
+*/
+
+var runCmd *cobra.Command
+
+func buildHTTPFile(mode runMode) {
+	runCmd = &cobra.Command{
+		Use:   "run <file.http>",
+		Short: "Send the requests in a .http file.",
+		Long: `Send the requests in a VS Code REST Client or JetBrains HTTP Client .http file,
+in order, to the current connection. Use --name to send just some of them.
+run request sends a saved request.`,
+		Example: fmt.Sprintf("  %s run api.http\n  %s run api.http --name login,users", config.AppName, config.AppName),
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runHTTPFile(args[0], httpFileNamesFlag); err != nil {
+				fmt.Printf("%s\n", t.Error(err))
+			}
+		},
+	}
+	rootCmd.AddCommand(runCmd)
+	initRunFlags()
+}
+
+// Run flags
+//
+
+var httpFileNamesFlag []string
+
+const httpFileNamesFlagKey = "name"
+
+func initRunFlags() {
+	runCmd.Flags().StringSliceVar(&httpFileNamesFlag, httpFileNamesFlagKey, nil, "Send only the requests with these names.")
+}
+
+// The file
+//
+
+type httpFile struct {
+	path     string
+	vars     map[string]string
+	requests []*httpFileRequest
+}
+
+type httpFileRequest struct {
+	Name     string
+	Line     int
+	Method   string
+	URL      string
+	Headers  [][2]string
+	Body     string
+	BodyFile string // < file
+	FillBody bool   // <@ file
+	Handlers []string
+}
+
+func (r *httpFileRequest) title() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("%s %s", r.Method, r.URL)
+}
+
+var (
+	httpFileVarRE     = regexp.MustCompile(`^@([A-Za-z_][A-Za-z0-9_.-]*)\s*=\s*(.*)$`)
+	httpFileNameRE    = regexp.MustCompile(`^(?:#|//)\s*@name\s+(\S+)`)
+	httpFileRequestRE = regexp.MustCompile(`^(GET|POST|PUT|PATCH|DELETE|HEAD|OPTIONS|TRACE|CONNECT)\s+(\S.*)$`)
+	httpFileVersionRE = regexp.MustCompile(`\s+HTTP/[0-9.]+$`)
+)
+
+func readHTTPFile(path string) (*httpFile, error) {
+	b, err := ioutil.ReadFile(expandHome(path))
+	if err != nil {
+		return nil, err
+	}
+	f := &httpFile{path: path, vars: map[string]string{}}
+
+	const (
+		before = iota
+		headers
+		body
+		handler
+	)
+	var r *httpFileRequest
+	state := before
+	blockName := ""
+	bodyLines := []string{}
+	script := []string{}
+	inScript := false
+
+	finish := func() {
+		if r != nil {
+			r.Body = strings.TrimRight(strings.Join(bodyLines, "\n"), "\n \t")
+			f.requests = append(f.requests, r)
+		}
+		r, state, bodyLines = nil, before, nil
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimRight(s.Text(), "\r")
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "###") {
+			finish()
+			blockName = strings.TrimSpace(strings.TrimLeft(trimmed, "#"))
+			continue
+		}
+
+		switch state {
+		case before:
+			switch {
+			case trimmed == "":
+			case httpFileNameRE.MatchString(trimmed):
+				blockName = httpFileNameRE.FindStringSubmatch(trimmed)[1]
+			case strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "//"):
+			case httpFileVarRE.MatchString(trimmed):
+				m := httpFileVarRE.FindStringSubmatch(trimmed)
+				f.vars[m[1]] = strings.TrimSpace(m[2])
+			default:
+				r = &httpFileRequest{Name: blockName, Line: n, Method: http.MethodGet, URL: trimmed}
+				if m := httpFileRequestRE.FindStringSubmatch(trimmed); m != nil {
+					r.Method, r.URL = m[1], m[2]
+				}
+				r.URL = httpFileVersionRE.ReplaceAllString(r.URL, "")
+				state = headers
+			}
+
+		case headers:
+			switch {
+			case trimmed == "":
+				state = body
+			case (strings.HasPrefix(trimmed, "?") || strings.HasPrefix(trimmed, "&")) && len(r.Headers) == 0:
+				r.URL += trimmed
+			case strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "//"):
+			default:
+				i := strings.Index(trimmed, ":")
+				if i <= 0 {
+					return nil, fmt.Errorf("%s:%d: %q isn't a header", path, n, trimmed)
+				}
+				r.Headers = append(r.Headers, [2]string{strings.TrimSpace(trimmed[:i]), strings.TrimSpace(trimmed[i+1:])})
+			}
+
+		case body, handler:
+			switch {
+			case inScript:
+				if i := strings.Index(line, "%}"); i >= 0 {
+					script = append(script, line[:i])
+					r.Handlers = append(r.Handlers, strings.Join(script, "\n"))
+					inScript = false
+				} else {
+					script = append(script, line)
+				}
+			case strings.HasPrefix(trimmed, "> {%"):
+				state = handler
+				rest := strings.TrimPrefix(trimmed, "> {%")
+				if i := strings.Index(rest, "%}"); i >= 0 {
+					r.Handlers = append(r.Handlers, rest[:i])
+				} else {
+					script, inScript = []string{rest}, true
+				}
+			case strings.HasPrefix(trimmed, ">") || strings.HasPrefix(trimmed, "<>"):
+				state = handler // Handler files and response references.
+			case state == handler:
+			case len(bodyLines) == 0 && strings.HasPrefix(trimmed, "<@ "):
+				r.BodyFile, r.FillBody = strings.TrimSpace(trimmed[3:]), true
+			case len(bodyLines) == 0 && strings.HasPrefix(trimmed, "< "):
+				r.BodyFile = strings.TrimSpace(trimmed[2:])
+			case r.BodyFile == "":
+				bodyLines = append(bodyLines, line)
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if inScript {
+		return nil, fmt.Errorf("%s: a handler script for %s doesn't end with %%}", path, r.title())
+	}
+	finish()
+	return f, nil
+}
+
+// Running
+//
+
+// httpFileRun is the state of running a file: the responses so far and the globals the handlers set.
+type httpFileRun struct {
+	file      *httpFile
+	conn      *connection.Connection
+	globals   map[string]string
+	responses map[string]*httpFileResponse
+	running   map[string]bool
+	warned    map[string]bool
+	sent      map[*httpFileRequest]bool // Including the ones sent early for their responses.
+}
+
+type httpFileResponse struct {
+	header http.Header
+	body   []byte
+}
+
+func runHTTPFile(path string, names []string) error {
+	f, err := readHTTPFile(path)
+	if err != nil {
+		return err
+	}
+	if len(f.requests) == 0 {
+		return fmt.Errorf("there aren't any requests in %s", path)
+	}
+	conn, err := connection.GetCurrentConnection()
+	if err != nil {
+		return err
+	}
+
+	toRun := f.requests
+	if len(names) > 0 {
+		toRun = nil
+		for _, name := range names {
+			r := f.request(name)
+			if r == nil {
+				return fmt.Errorf("there isn't a request named %q in %s", name, path)
+			}
+			toRun = append(toRun, r)
+		}
+	}
+
+	run := &httpFileRun{file: f, conn: conn, globals: map[string]string{},
+		responses: map[string]*httpFileResponse{}, running: map[string]bool{}, warned: map[string]bool{},
+		sent: map[*httpFileRequest]bool{}}
+	for _, r := range toRun {
+		if run.sent[r] {
+			continue
+		}
+		if err := run.send(r); err != nil {
+			fmt.Printf("%s\n", t.Error(fmt.Errorf("%s: %v", r.title(), err)))
+		}
+	}
+	return nil
+}
+
+func (f *httpFile) request(name string) *httpFileRequest {
+	for _, r := range f.requests {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+func (run *httpFileRun) send(r *httpFileRequest) error {
+	run.sent[r] = true
+	if r.Name != "" {
+		run.running[r.Name] = true
+		defer delete(run.running, r.Name)
+	}
+	req, err := run.request(r)
+	if err != nil {
+		return err
+	}
+	if tableOutputFormat() {
+		fmt.Printf("%s\n", t.Title("### %s", r.title()))
+	}
+
+	se, resp, err := sendRequest(req)
+	if resp != nil {
+		body, _ := responseBody(resp)
+		fr := &httpFileResponse{header: resp.Header.Clone(), body: body}
+		if r.Name != "" {
+			run.responses[r.Name] = fr
+		}
+		run.handle(r, fr)
+	}
+	httpDisplay(se, resp, err)
+	return nil
+}
+
+// request builds the request on the connection, with the variables filled in.
+func (run *httpFileRun) request(r *httpFileRequest) (*http.Request, error) {
+	target, err := run.fill(r.URL)
+	if err != nil {
+		return nil, err
+	}
+	path := target
+	if !strings.HasPrefix(target, "/") {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		if path = servicePath(run.conn, u); u.RawQuery != "" {
+			path += "?" + u.RawQuery
+		}
+	}
+
+	body := r.Body
+	if r.BodyFile != "" {
+		fn := r.BodyFile
+		if !filepath.IsAbs(fn) {
+			fn = filepath.Join(filepath.Dir(run.file.path), fn)
+		}
+		b, err := ioutil.ReadFile(expandHome(fn))
+		if err != nil {
+			return nil, err
+		}
+		body = string(b)
+		if !r.FillBody {
+			return run.newRequest(r, path, body)
+		}
+	}
+	if body, err = run.fill(body); err != nil {
+		return nil, err
+	}
+	return run.newRequest(r, path, body)
+}
+
+func (run *httpFileRun) newRequest(r *httpFileRequest, path, body string) (*http.Request, error) {
+	req, err := http.NewRequest(r.Method, strings.TrimSuffix(run.conn.ServiceURL, "/")+path, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range r.Headers {
+		v, err := run.fill(h[1])
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add(h[0], v)
+	}
+	for k, v := range run.conn.Headers {
+		if req.Header.Get(k) == "" {
+			req.Header.Set(k, v)
+		}
+	}
+	return withConnection(req, run.conn), nil
+}
+
+// Variables
+//
+
+var httpFileRefRE = regexp.MustCompile(`\{\{\s*([^{}]+?)\s*\}\}`)
+
+// Deep enough for variables made of variables, not so deep a loop goes on for long.
+const maxFillDepth = 10
+
+func (run *httpFileRun) fill(s string) (string, error) {
+	return run.fillDepth(s, 0)
+}
+
+func (run *httpFileRun) fillDepth(s string, depth int) (string, error) {
+	if depth > maxFillDepth {
+		return "", fmt.Errorf("variables nest too deep in %q", s)
+	}
+	var ferr error
+	filled := httpFileRefRE.ReplaceAllStringFunc(s, func(m string) string {
+		if ferr != nil {
+			return m
+		}
+		v, err := run.value(httpFileRefRE.FindStringSubmatch(m)[1], depth)
+		if err != nil {
+			ferr = err
+			return m
+		}
+		return v
+	})
+	return filled, ferr
+}
+
+func (run *httpFileRun) value(ref string, depth int) (string, error) {
+	if strings.HasPrefix(ref, "$") {
+		return systemVariable(ref)
+	}
+	if parts := strings.SplitN(ref, ".", 3); len(parts) == 3 && (parts[1] == "response" || parts[1] == "request") {
+		return run.requestVariable(parts[0], parts[1], parts[2])
+	}
+	if v, ok := run.globals[ref]; ok {
+		return v, nil
+	}
+	if v, ok := run.file.vars[ref]; ok {
+		return run.fillDepth(v, depth+1)
+	}
+	if v, ok := sessionVars()[strings.ToLower(ref)]; ok {
+		return v, nil
+	}
+	if ref == "host" || ref == "baseUrl" {
+		return strings.TrimSuffix(run.conn.ServiceURL, "/"), nil
+	}
+	return "", fmt.Errorf("{{%s}} isn't defined", ref)
+}
+
+// requestVariable is {{name.response.body.path}} or {{name.response.headers.Name}}.
+func (run *httpFileRun) requestVariable(name, part, rest string) (string, error) {
+	if part != "response" {
+		return "", fmt.Errorf("{{%s.%s.%s}}: only responses can be used", name, part, rest)
+	}
+	fr, ok := run.responses[name]
+	if !ok {
+		r := run.file.request(name)
+		switch {
+		case r == nil:
+			return "", fmt.Errorf("there isn't a request named %q", name)
+		case run.running[name]:
+			return "", fmt.Errorf("request %q uses its own response", name)
+		}
+		if err := run.send(r); err != nil {
+			return "", err
+		}
+		if fr, ok = run.responses[name]; !ok {
+			return "", fmt.Errorf("request %q didn't get a response", name)
+		}
+	}
+
+	switch {
+	case rest == "body.*" || rest == "body":
+		return string(fr.body), nil
+	case strings.HasPrefix(rest, "body."):
+		return jsonBodyValue(fr.body, strings.TrimPrefix(rest, "body."))
+	case strings.HasPrefix(rest, "headers."):
+		h := strings.TrimPrefix(rest, "headers.")
+		if v := fr.header.Get(h); v != "" {
+			return v, nil
+		}
+		return "", fmt.Errorf("the %s response doesn't have a %s header", name, h)
+	}
+	return "", fmt.Errorf("{{%s.response.%s}}: use body.<path> or headers.<name>", name, rest)
+}
+
+func jsonBodyValue(body []byte, path string) (string, error) {
+	doc, err := decodeJSON(body)
+	if err != nil {
+		return "", fmt.Errorf("the response isn't JSON: %v", err)
+	}
+	v, err := lookupPath(doc, path)
+	if err != nil {
+		return "", err
+	}
+	return jsonValueString(v), nil
+}
+
+// systemVariable is one of the {{$name args}} built ins.
+func systemVariable(ref string) (string, error) {
+	fs := strings.Fields(ref)
+	switch fs[0] {
+	case "$guid", "$uuid", "$random.uuid":
+		return newUUID(), nil
+	case "$timestamp":
+		return strconv.FormatInt(time.Now().Unix(), 10), nil
+	case "$isoTimestamp":
+		return time.Now().UTC().Format(time.RFC3339), nil
+	case "$datetime":
+		now := time.Now().UTC()
+		if len(fs) > 1 && strings.EqualFold(fs[1], "rfc1123") {
+			return now.Format(http.TimeFormat), nil
+		}
+		return now.Format(time.RFC3339), nil
+	case "$randomInt":
+		min, max := int64(0), int64(1000)
+		if len(fs) == 3 {
+			var err1, err2 error
+			min, err1 = strconv.ParseInt(fs[1], 10, 64)
+			max, err2 = strconv.ParseInt(fs[2], 10, 64)
+			if err1 != nil || err2 != nil {
+				return "", fmt.Errorf("{{%s}}: use $randomInt <min> <max>", ref)
+			}
+		}
+		v, err := randInt(min, max)
+		if err != nil {
+			return "", fmt.Errorf("{{%s}}: %v", ref, err)
+		}
+		return v, nil
+	case "$processEnv":
+		if len(fs) != 2 {
+			return "", fmt.Errorf("{{%s}}: use $processEnv <name>", ref)
+		}
+		return os.Getenv(fs[1]), nil
+	}
+	return "", fmt.Errorf("{{%s}} isn't a variable we know", ref)
+}
+
+// Handlers
+//
+
+var (
+	handlerSetRE    = regexp.MustCompile(`client\.global\.set\(\s*["']([^"']+)["']\s*,\s*((?:[^;()]|\([^()]*\))+?)\s*\)\s*;?`)
+	handlerHeaderRE = regexp.MustCompile(`^response\.headers\.valueOf\(\s*["']([^"']+)["']\s*\)$`)
+	handlerStringRE = regexp.MustCompile(`^["']([^"']*)["']$`)
+)
+
+// handle does the client.global.set calls in the request's handlers.
+func (run *httpFileRun) handle(r *httpFileRequest, fr *httpFileResponse) {
+	for _, script := range r.Handlers {
+		sets := handlerSetRE.FindAllStringSubmatch(script, -1)
+		for _, m := range sets {
+			v, err := handlerValue(m[2], fr)
+			if err != nil {
+				fmt.Printf("%s\n", t.Warn("%s: client.global.set(%q): %v", r.title(), m[1], err))
+				continue
+			}
+			run.globals[m[1]] = v
+		}
+		if rest := strings.TrimSpace(handlerSetRE.ReplaceAllString(script, "")); rest != "" && !run.warned[r.title()] {
+			run.warned[r.title()] = true
+			fmt.Printf("%s\n", t.Warn("%s: only client.global.set is done in response handlers, the rest of the script isn't run.", r.title()))
+		}
+	}
+}
+
+func handlerValue(expr string, fr *httpFileResponse) (string, error) {
+	switch {
+	case expr == "response.body":
+		return string(fr.body), nil
+	case strings.HasPrefix(expr, "response.body."):
+		return jsonBodyValue(fr.body, strings.TrimPrefix(expr, "response.body."))
+	case handlerHeaderRE.MatchString(expr):
+		h := handlerHeaderRE.FindStringSubmatch(expr)[1]
+		if v := fr.header.Get(h); v != "" {
+			return v, nil
+		}
+		return "", fmt.Errorf("there's no %s header", h)
+	case handlerStringRE.MatchString(expr):
+		return handlerStringRE.FindStringSubmatch(expr)[1], nil
+	}
+	return "", errors.New("the value has to be response.body.<path>, response.headers.valueOf(\"Name\") or a string")
+}
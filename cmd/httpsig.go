@@ -0,0 +1,564 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	connection "github.com/jdrivas/conman"
+	t "github.com/jdrivas/termtext"
+	"github.com/juju/ansiterm"
+)
+
+/*
+HTTP Message Signatures (RFC 9421)
+
+Configured on a connection with auth mode httpsig:
+
+            auth:
+                  mode: httpsig
+                  keyId: my-key
+                  algorithm: hmac-sha256     # or ed25519, rsa-pss-sha512
+                  key: shared-secret         # hmac secret, or
+                  keyFile: ~/keys/client.pem # PEM private key (or hmac secret)
+                  label: sig1
+                  components: ["@method", "@path", "@authority", "content-digest", "x-request-id"]
+                  verifyAlgorithm: ed25519   # for checking signed responses
+                  verifyKeyFile: ~/keys/server.pub.pem
+
+If components aren't configured we cover @method, @path, @authority and,
+when there is a body, content-digest. A content-digest header is added to the
+request if it's covered and not already there.
+
+Responses that come back with Signature-Input/Signature headers are verified
+with the verify key (for hmac the signing key is used if there isn't one), and
+the result is shown by httpDisplay.
+*/
+
+// HTTP signature configuration keys, relative to the connection auth configuration.
+const (
+	httpSigKeyIDKey           = "keyId"           // string
+	httpSigAlgorithmKey       = "algorithm"       // string
+	httpSigKeyKey             = "key"             // string
+	httpSigKeyFileKey         = "keyFile"         // string
+	httpSigLabelKey           = "label"           // string
+	httpSigComponentsKey      = "components"      // []string
+	httpSigVerifyAlgorithmKey = "verifyAlgorithm" // string
+	httpSigVerifyKeyKey       = "verifyKey"       // string
+	httpSigVerifyKeyFileKey   = "verifyKeyFile"   // string
+)
+
+// Algorithms
+const (
+	httpSigHMACSHA256   = "hmac-sha256"
+	httpSigEd25519      = "ed25519"
+	httpSigRSAPSSSHA512 = "rsa-pss-sha512"
+)
+
+const httpSigDefaultLabel = "sig1"
+
+// httpSigDetails is what went into the request signature.
+type httpSigDetails struct {
+	Label          string
+	KeyID          string
+	Algorithm      string
+	SignatureInput string
+	SignatureBase  string
+	Signature      string
+}
+
+// signatureCheck is the result of verifying one response signature.
+type signatureCheck struct {
+	Label      string
+	KeyID      string
+	Algorithm  string
+	Components []string
+	Err        error // nil when verified.
+}
+
+func signHTTPSig(conn *connection.Connection, req *http.Request) (signingDetails, error) {
+	alg := strings.ToLower(authString(conn, httpSigAlgorithmKey))
+	if alg == "" {
+		alg = httpSigHMACSHA256
+	}
+	key, err := httpSigKey(conn, alg, httpSigKeyKey, httpSigKeyFileKey, true)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := requestBody(req)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read request body for signing: %v", err)
+	}
+
+	components := authStringSlice(conn, httpSigComponentsKey)
+	if len(components) == 0 {
+		components = []string{"@method", "@path", "@authority"}
+		if len(body) > 0 {
+			components = append(components, "content-digest")
+		}
+	}
+	for _, c := range components {
+		if strings.ToLower(c) == "content-digest" && req.Header.Get("Content-Digest") == "" {
+			req.Header.Set("Content-Digest", contentDigest(body))
+		}
+	}
+
+	d := &httpSigDetails{
+		Label:     authString(conn, httpSigLabelKey),
+		KeyID:     authString(conn, httpSigKeyIDKey),
+		Algorithm: alg,
+	}
+	if d.Label == "" {
+		d.Label = httpSigDefaultLabel
+	}
+
+	var items []string
+	for _, c := range components {
+		items = append(items, strconv.Quote(strings.ToLower(c)))
+	}
+	params := fmt.Sprintf("(%s);created=%d", strings.Join(items, " "), time.Now().Unix())
+	if d.KeyID != "" {
+		params += fmt.Sprintf(";keyid=%s", strconv.Quote(d.KeyID))
+	}
+	params += fmt.Sprintf(";alg=%s", strconv.Quote(alg))
+
+	d.SignatureBase, err = signatureBase(items, params, req, nil)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := httpSigSign(alg, key, []byte(d.SignatureBase))
+	if err != nil {
+		return nil, err
+	}
+
+	d.SignatureInput = fmt.Sprintf("%s=%s", d.Label, params)
+	d.Signature = fmt.Sprintf("%s=:%s:", d.Label, base64.StdEncoding.EncodeToString(sig))
+	req.Header.Set("Signature-Input", d.SignatureInput)
+	req.Header.Set("Signature", d.Signature)
+	return d, nil
+}
+
+// verifyResponseSignatures checks every signature on the response.
+// Returns nil if the response isn't signed.
+func verifyResponseSignatures(conn *connection.Connection, resp *http.Response, body []byte, err error) (checks []signatureCheck) {
+	inputs := parseSFDictionary(strings.Join(resp.Header["Signature-Input"], ", "))
+	if len(inputs) == 0 {
+		return nil
+	}
+	sigs := parseSFDictionary(strings.Join(resp.Header["Signature"], ", "))
+
+	for _, in := range inputs {
+		sc := signatureCheck{Label: in.key}
+		items, params := splitSignatureParams(in.value)
+		for _, it := range items {
+			sc.Components = append(sc.Components, strings.Replace(it, "\"", "", -1))
+		}
+		sc.KeyID = sfParam(params, "keyid")
+		sc.Algorithm = sfParam(params, "alg")
+		sc.Err = err
+		if sc.Err == nil {
+			sc.Err = verifySignature(conn, resp, body, in, sigs, items, &sc)
+		}
+		checks = append(checks, sc)
+	}
+	return checks
+}
+
+func verifySignature(conn *connection.Connection, resp *http.Response, body []byte,
+	in sfMember, sigs []sfMember, items []string, sc *signatureCheck) error {
+
+	if conn == nil {
+		return errors.New("no connection configuration to verify with")
+	}
+	var sigValue string
+	for _, s := range sigs {
+		if s.key == in.key {
+			sigValue = strings.Trim(s.value, ":")
+		}
+	}
+	if sigValue == "" {
+		return fmt.Errorf("no Signature for label %q", in.key)
+	}
+	sig, err := base64.StdEncoding.DecodeString(sigValue)
+	if err != nil {
+		return fmt.Errorf("bad signature encoding: %v", err)
+	}
+
+	alg := strings.ToLower(authString(conn, httpSigVerifyAlgorithmKey))
+	if alg == "" {
+		alg = strings.ToLower(authString(conn, httpSigAlgorithmKey))
+	}
+	if alg == "" {
+		alg = sc.Algorithm
+	}
+	if sc.Algorithm != "" && sc.Algorithm != alg {
+		return fmt.Errorf("response signed with %q, expected %q", sc.Algorithm, alg)
+	}
+	sc.Algorithm = alg
+
+	key, err := httpSigKey(conn, alg, httpSigVerifyKeyKey, httpSigVerifyKeyFileKey, false)
+	if err != nil && alg == httpSigHMACSHA256 {
+		key, err = httpSigKey(conn, alg, httpSigKeyKey, httpSigKeyFileKey, true)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, c := range sc.Components {
+		if c == "content-digest" {
+			if err := checkContentDigest(resp.Header.Get("Content-Digest"), body); err != nil {
+				return err
+			}
+		}
+	}
+
+	base, err := signatureBase(items, strings.TrimSpace(in.value), resp.Request, resp)
+	if err != nil {
+		return err
+	}
+	return httpSigVerify(alg, key, []byte(base), sig)
+}
+
+// Signature Base
+//
+
+// signatureBase builds the RFC 9421 signature base for the covered components.
+// When resp is non-nil components come from the response, unless they carry
+// the ;req parameter.
+func signatureBase(items []string, params string, req *http.Request, resp *http.Response) (string, error) {
+	var b strings.Builder
+	for _, it := range items {
+		name := it
+		fromReq := resp == nil
+		if i := strings.Index(it, ";"); i >= 0 {
+			name = it[:i]
+			fromReq = fromReq || strings.Contains(it[i:], ";req")
+		}
+		name = strings.Trim(name, "\"")
+
+		var v string
+		var err error
+		if fromReq {
+			v, err = componentValue(name, req, nil)
+		} else {
+			v, err = componentValue(name, req, resp)
+		}
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s: %s\n", it, v)
+	}
+	fmt.Fprintf(&b, "\"@signature-params\": %s", params)
+	return b.String(), nil
+}
+
+func componentValue(name string, req *http.Request, resp *http.Response) (string, error) {
+	if strings.HasPrefix(name, "@") {
+		if req == nil {
+			return "", fmt.Errorf("component %q needs the request", name)
+		}
+		switch name {
+		case "@method":
+			return strings.ToUpper(req.Method), nil
+		case "@path":
+			if p := req.URL.EscapedPath(); p != "" {
+				return p, nil
+			}
+			return "/", nil
+		case "@query":
+			return "?" + req.URL.RawQuery, nil
+		case "@authority":
+			if req.Host != "" {
+				return strings.ToLower(req.Host), nil
+			}
+			return strings.ToLower(req.URL.Host), nil
+		case "@scheme":
+			return strings.ToLower(req.URL.Scheme), nil
+		case "@target-uri":
+			return req.URL.String(), nil
+		case "@request-target":
+			return req.URL.RequestURI(), nil
+		case "@status":
+			if resp == nil {
+				return "", errors.New("component \"@status\" is only for responses")
+			}
+			return strconv.Itoa(resp.StatusCode), nil
+		}
+		return "", fmt.Errorf("unsupported derived component %q", name)
+	}
+
+	h := req.Header
+	if resp != nil {
+		h = resp.Header
+	}
+	var vs []string
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		vs = append(vs, strings.TrimSpace(v))
+	}
+	if len(vs) == 0 {
+		return "", fmt.Errorf("covered component %q isn't in the message", name)
+	}
+	return strings.Join(vs, ", "), nil
+}
+
+// Content Digest (RFC 9530)
+//
+
+func contentDigest(body []byte) string {
+	h := sha256.Sum256(body)
+	return fmt.Sprintf("sha-256=:%s:", base64.StdEncoding.EncodeToString(h[:]))
+}
+
+func checkContentDigest(header string, body []byte) error {
+	if header == "" {
+		return errors.New("content-digest is covered but missing")
+	}
+	for _, m := range parseSFDictionary(header) {
+		var sum []byte
+		switch m.key {
+		case "sha-256":
+			h := sha256.Sum256(body)
+			sum = h[:]
+		case "sha-512":
+			h := sha512.Sum512(body)
+			sum = h[:]
+		default:
+			continue
+		}
+		if strings.Trim(m.value, ":") != base64.StdEncoding.EncodeToString(sum) {
+			return fmt.Errorf("content-digest %s doesn't match the body", m.key)
+		}
+		return nil
+	}
+	return fmt.Errorf("no supported algorithm in content-digest %q", header)
+}
+
+// Keys and Algorithms
+//
+
+// httpSigKey loads the key from the connection configuration.
+// private selects private keys for signing over public keys for verification.
+func httpSigKey(conn *connection.Connection, alg, keyKey, fileKey string, private bool) (interface{}, error) {
+	var raw []byte
+	if k := authString(conn, keyKey); k != "" {
+		raw = []byte(k)
+	} else if fn := authString(conn, fileKey); fn != "" {
+		b, err := ioutil.ReadFile(expandHome(fn))
+		if err != nil {
+			return nil, err
+		}
+		raw = b
+	} else {
+		return nil, fmt.Errorf("connection %q has no %s or %s configured", conn.Name, keyKey, fileKey)
+	}
+
+	if alg == httpSigHMACSHA256 {
+		return bytes.TrimSpace(raw), nil
+	}
+
+	blk, _ := pem.Decode(raw)
+	if blk == nil {
+		return nil, fmt.Errorf("expected a PEM key for %s", alg)
+	}
+	var key interface{}
+	var err error
+	switch {
+	case private && blk.Type == "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(blk.Bytes)
+	case private:
+		key, err = x509.ParsePKCS8PrivateKey(blk.Bytes)
+	case blk.Type == "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(blk.Bytes)
+	case blk.Type == "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(blk.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		key, err = x509.ParsePKIXPublicKey(blk.Bytes)
+	}
+	return key, err
+}
+
+func httpSigSign(alg string, key interface{}, base []byte) ([]byte, error) {
+	switch alg {
+	case httpSigHMACSHA256:
+		return hmacSHA256(key.([]byte), base), nil
+	case httpSigEd25519:
+		if k, ok := key.(ed25519.PrivateKey); ok {
+			return ed25519.Sign(k, base), nil
+		}
+	case httpSigRSAPSSSHA512:
+		if k, ok := key.(*rsa.PrivateKey); ok {
+			h := sha512.Sum512(base)
+			return rsa.SignPSS(rand.Reader, k, crypto.SHA512, h[:], &rsa.PSSOptions{SaltLength: 64})
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+	return nil, fmt.Errorf("key type %T doesn't work with %s", key, alg)
+}
+
+func httpSigVerify(alg string, key interface{}, base, sig []byte) error {
+	switch alg {
+	case httpSigHMACSHA256:
+		if !hmac.Equal(hmacSHA256(key.([]byte), base), sig) {
+			return errors.New("signature doesn't match")
+		}
+		return nil
+	case httpSigEd25519:
+		if k, ok := key.(ed25519.PublicKey); ok {
+			if !ed25519.Verify(k, base, sig) {
+				return errors.New("signature doesn't match")
+			}
+			return nil
+		}
+	case httpSigRSAPSSSHA512:
+		if k, ok := key.(*rsa.PublicKey); ok {
+			h := sha512.Sum512(base)
+			return rsa.VerifyPSS(k, crypto.SHA512, h[:], sig, &rsa.PSSOptions{SaltLength: 64})
+		}
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+	return fmt.Errorf("key type %T doesn't work with %s", key, alg)
+}
+
+// Structured Fields
+// Just enough of RFC 8941 dictionaries to read Signature-Input, Signature and Content-Digest.
+//
+
+type sfMember struct {
+	key, value string
+}
+
+// Split on top level commas, minding quotes and parens.
+func parseSFDictionary(s string) (members []sfMember) {
+	var cur strings.Builder
+	depth, quoted := 0, false
+	add := func() {
+		m := strings.TrimSpace(cur.String())
+		cur.Reset()
+		if m == "" {
+			return
+		}
+		if i := strings.Index(m, "="); i > 0 {
+			members = append(members, sfMember{key: m[:i], value: m[i+1:]})
+		} else {
+			members = append(members, sfMember{key: m})
+		}
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quoted && c == '\\' && i+1 < len(s):
+			cur.WriteByte(c)
+			i++
+			c = s[i]
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == '(':
+			depth++
+		case !quoted && c == ')':
+			depth--
+		case !quoted && depth == 0 && c == ',':
+			add()
+			continue
+		}
+		cur.WriteByte(c)
+	}
+	add()
+	return members
+}
+
+// Split an inner list with parameters, e.g. ("@method" "@path");created=1,
+// into its items and the parameter string.
+func splitSignatureParams(v string) (items []string, params string) {
+	v = strings.TrimSpace(v)
+	if !strings.HasPrefix(v, "(") {
+		return nil, v
+	}
+	end := strings.LastIndex(v, ")")
+	if end < 0 {
+		return nil, v
+	}
+	var cur strings.Builder
+	quoted := false
+	for i := 1; i < end; i++ {
+		c := v[i]
+		if c == '"' {
+			quoted = !quoted
+		}
+		if c == ' ' && !quoted {
+			if cur.Len() > 0 {
+				items = append(items, cur.String())
+				cur.Reset()
+			}
+			continue
+		}
+		cur.WriteByte(c)
+	}
+	if cur.Len() > 0 {
+		items = append(items, cur.String())
+	}
+	return items, v[end+1:]
+}
+
+// sfParam returns the named parameter from a ;name=value;... string, unquoted.
+func sfParam(params, name string) string {
+	for _, p := range strings.Split(params, ";") {
+		if kv := strings.SplitN(p, "=", 2); len(kv) == 2 && strings.TrimSpace(kv[0]) == name {
+			if uq, err := strconv.Unquote(kv[1]); err == nil {
+				return uq
+			}
+			return kv[1]
+		}
+	}
+	return ""
+}
+
+// Display
+//
+
+// Describe prints out the signature and what went into it.
+func (d *httpSigDetails) Describe() {
+	w := ansiterm.NewTabWriter(os.Stdout, 4, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\n", t.Title("HTTP Signature\t"))
+	fmt.Fprintf(w, "%s\t%s\n", t.Title("Key ID:"), t.Text("%s", d.KeyID))
+	fmt.Fprintf(w, "%s\t%s\n", t.Title("Algorithm:"), t.Text("%s", d.Algorithm))
+	fmt.Fprintf(w, "%s\t%s\n", t.Title("Signature-Input:"), t.Text("%s", d.SignatureInput))
+	fmt.Fprintf(w, "%s\t%s\n", t.Title("Signature:"), t.Text("%s", d.Signature))
+	w.Flush()
+	fmt.Printf("%s\n%s\n", t.Title("Signature Base:"), t.Text("%s", d.SignatureBase))
+}
+
+func displaySignatureChecks(checks []signatureCheck) {
+	w := ansiterm.NewTabWriter(os.Stdout, 4, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\n", t.Title("Signature\tKey ID\tAlgorithm\tComponents\tVerified"))
+	for _, sc := range checks {
+		result := t.Success("verified")
+		if sc.Err != nil {
+			result = t.Fail("%s", sc.Err.Error())
+		}
+		fmt.Fprintf(w, "%s\t%s\n",
+			t.Text("%s\t%s\t%s\t%s", sc.Label, sc.KeyID, sc.Algorithm, strings.Join(sc.Components, " ")), result)
+	}
+	w.Flush()
+}
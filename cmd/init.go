@@ -2,11 +2,14 @@ package cmd
 
 import (
 	"fmt"
+	"io/ioutil"
+	"strings"
 
 	connection "github.com/jdrivas/conman"
 	t "github.com/jdrivas/termtext"
 	config "github.com/jdrivas/vconfig"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 /*
@@ -217,7 +220,7 @@ So:
 //
 
 // packageFuncs is the list of functions we want to call reread viper variables when set/reset.
-var packageFuncs = []func(){t.InitTerm, connection.InitConnections}
+var packageFuncs = []func(){t.InitTerm, connection.InitConnections, initTransport}
 
 func moduleInit() {
 	if config.Debug() {
@@ -229,6 +232,30 @@ func moduleInit() {
 	}
 }
 
+// earlyFlags picks the config file and the connection out of a command line,
+// for what has to happen before cobra parses it.
+func earlyFlags(args []string) (configFile, conn string) {
+	fs := pflag.NewFlagSet("early", pflag.ContinueOnError)
+	fs.ParseErrorsWhitelist.UnknownFlags = true
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&configFile, configFlagKey, "", "")
+	fs.StringVarP(&conn, connectionFlagKey, "c", "", "")
+	fs.Parse(args)
+	return configFile, conn
+}
+
+func earlyConnection(args []string) string {
+	_, conn := earlyFlags(args)
+	return conn
+}
+
+// readConfig reads the config file the app command line asks for, before cobra parses
+// the command line, so the api operations can be built from it (see buildAPIOperations).
+func readConfig(args []string) {
+	config.ConfigFileName, _ = earlyFlags(args)
+	config.InitConfig()
+}
+
 // cobra.OnInitialize registers a function that is called "everytime a command's Execute method is called".
 // Praticaly this is like a PersistentPreRun on root but without having to bother with the commnand tree.
 var firstCobraInit = true
@@ -250,12 +277,9 @@ func doCobraOnInit() {
 		// Get all the set flags from the application (not interactive) command line ...
 		config.UpdateChangedFlags()
 		// and apply the results of all bound flags to viper
+		// The config file has already been read, see readConfig.
 		config.Apply()
-
-		// Set up general configuration (read config file.)
-		// We do this here because we want to be able to use
-		// the command line flag to modify the configuration file.
-		config.InitConfig()
+		applyVarFlags(true)
 		firstCobraInit = false
 	} else {
 		if config.Debug() {
@@ -265,6 +289,7 @@ func doCobraOnInit() {
 		// apply them, without updating the bind variables.
 		// rootPost will apply BindVariables on another pass.
 		config.ApplyFromFlags(rootCmd.PersistentFlags())
+		applyVarFlags(false)
 	}
 
 }
@@ -289,9 +314,10 @@ func rootPost(cmd *cobra.Command, args []string) {
 		defer t.Pxf()
 	}
 
-	reset()        // Reset the environment for another pass through
-	config.Apply() // Reapply apply those set on the command line.
-	moduleInit()   // inform interested parties
+	saveCassettes() // Before the flags go away.
+	reset()         // Reset the environment for another pass through
+	config.Apply()  // Reapply apply those set on the command line.
+	moduleInit()    // inform interested parties
 }
 
 // Reset the flags and bindings.
@@ -303,6 +329,20 @@ func reset() {
 
 	rootCmd.ResetFlags() // Literally erases the flags from the tree.
 	initFlags()
+	httpCmd.ResetFlags()
+	initHTTPFlags()
+	jwtCmd.ResetFlags()
+	initJWTFlags()
+	benchCmd.ResetFlags()
+	initBenchFlags()
+	mockCmd.ResetFlags()
+	initMockFlags()
+	proxyCmd.ResetFlags()
+	initProxyFlags()
+	httpExportCmd.ResetFlags()
+	initHTTPExportFlags()
+	runCmd.ResetFlags()
+	initRunFlags()
 }
 
 // Initialize Flags
@@ -310,8 +350,16 @@ func reset() {
 
 var (
 	debugFlag, verboseFlag, jsonFlag bool
+	outputFlag                       string
 	screenProfileFlag                string
 	connectionFlag                   string
+	tlsCertFlag, tlsKeyFlag          string
+	tlsPKCS12Flag, tlsPKCS12PassFlag string
+	tlsCAFlag, tlsServerNameFlag     string
+	tlsMinVersionFlag                string
+	insecureFlag                     bool
+	recordFlag, replayFlag           string
+	matchFlag                        string
 )
 
 const (
@@ -319,8 +367,20 @@ const (
 	debugFlagKey         = "debug"
 	verboseFlagKey       = "verbose"
 	jsonFlagKey          = "json"
+	outputFlagKey        = "output"
 	screenProfileFlagKey = "screen"
 	connectionFlagKey    = "connection"
+	tlsCertFlagKey       = "tls-cert"
+	tlsKeyFlagKey        = "tls-key"
+	tlsPKCS12FlagKey     = "tls-pkcs12"
+	tlsPKCS12PassFlagKey = "tls-pkcs12-password"
+	tlsCAFlagKey         = "tls-ca"
+	tlsServerNameFlagKey = "tls-server-name"
+	tlsMinVersionFlagKey = "tls-min-version"
+	insecureFlagKey      = "insecure"
+	recordFlagKey        = "record"
+	replayFlagKey        = "replay"
+	matchFlagKey         = "match"
 )
 
 // Create flags and bind them to  viper variables.
@@ -358,9 +418,50 @@ func initFlags() {
 		defaultJSON, "Print output in unencumbred JSON for easy scripting.")
 	config.Bind(t.JSONDisplayKey, rootCmd.PersistentFlags().Lookup(jsonFlagKey))
 
+	// Output
+	defaultOutput := ""
+	rootCmd.PersistentFlags().StringVarP(&outputFlag, outputFlagKey, "o",
+		defaultOutput, fmt.Sprintf("Output format: %s (default table).", strings.Join(outputFormats, ", ")))
+	config.Bind(outputKey, rootCmd.PersistentFlags().Lookup(outputFlagKey))
+
 	// ScreenProfile
 	defaultScreenProfile := t.ScreenNoColorDefaultKey
 	rootCmd.PersistentFlags().StringVarP(&screenProfileFlag, screenProfileFlagKey, "s",
 		defaultScreenProfile, "Set the screen profile for output (e.g. colors etc).")
 	config.Bind(t.ScreenProfileKey, rootCmd.PersistentFlags().Lookup(screenProfileFlagKey))
+
+	// TLS
+	// These bind to the top level tls keys which override the connection's settings.
+	tlsFlag := func(p *string, name, key, usage string) {
+		rootCmd.PersistentFlags().StringVar(p, name, "", usage)
+		config.Bind(tlsKey+"."+key, rootCmd.PersistentFlags().Lookup(name))
+	}
+	tlsFlag(&tlsCertFlag, tlsCertFlagKey, tlsCertKey, "PEM client certificate for mutual TLS.")
+	tlsFlag(&tlsKeyFlag, tlsKeyFlagKey, tlsKeyKey, "PEM key for the client certificate.")
+	tlsFlag(&tlsPKCS12Flag, tlsPKCS12FlagKey, tlsPKCS12Key, "PKCS#12 bundle with the client certificate and key.")
+	tlsFlag(&tlsPKCS12PassFlag, tlsPKCS12PassFlagKey, tlsPKCS12PasswordKey, "Password for the PKCS#12 bundle.")
+	tlsFlag(&tlsCAFlag, tlsCAFlagKey, tlsCAKey, "PEM CA bundle to trust instead of the system roots.")
+	tlsFlag(&tlsServerNameFlag, tlsServerNameFlagKey, tlsServerNameKey, "Server name to expect on the certificate (and send for SNI).")
+	tlsFlag(&tlsMinVersionFlag, tlsMinVersionFlagKey, tlsMinVersionKey, "Minimum TLS version (1.0, 1.1, 1.2, 1.3).")
+
+	defaultInsecure := false
+	rootCmd.PersistentFlags().BoolVarP(&insecureFlag, insecureFlagKey, "k",
+		defaultInsecure, "Don't verify the server's certificate. Really.")
+	config.Bind(tlsKey+"."+tlsInsecureKey, rootCmd.PersistentFlags().Lookup(insecureFlagKey))
+
+	// Variables, each one gets a flag of its own bound, see applyVarFlags.
+	rootCmd.PersistentFlags().StringArrayVar(&varFlag, varFlagKey, nil,
+		"Set a variable for ${name} in requests, as <name>=<value>.")
+
+	// Cassettes
+	rootCmd.PersistentFlags().StringVar(&recordFlag, recordFlagKey, "",
+		"Save the requests and responses to this cassette file.")
+	config.Bind(cassetteRecordKey, rootCmd.PersistentFlags().Lookup(recordFlagKey))
+	rootCmd.PersistentFlags().StringVar(&replayFlag, replayFlagKey, "",
+		"Answer requests from this cassette file instead of the network.")
+	config.Bind(cassetteReplayKey, rootCmd.PersistentFlags().Lookup(replayFlagKey))
+	rootCmd.PersistentFlags().StringVar(&matchFlag, matchFlagKey, "",
+		fmt.Sprintf("How replayed requests match recorded ones, some of: %s (default %s).",
+			strings.Join(matchRules, ","), strings.Join(defaultMatchRules, ",")))
+	config.Bind(cassetteMatchKey, rootCmd.PersistentFlags().Lookup(matchFlagKey))
 }
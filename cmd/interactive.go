@@ -78,6 +78,7 @@ func addInteractiveCommands() {
 func DoInteractive() {
 
 	addInteractiveCommands()
+	interactiveSession = true
 
 	readline.SetHistoryPath(fmt.Sprintf("./%s", config.HistoryFile))
 
@@ -97,7 +98,18 @@ func doICommand(line string) (err error) {
 	// addInteractiveCommands()
 
 	args := strings.Fields(line) // Don't use strings.Split - it won't eat white space.
+	if isCurlLine(line) {
+		// Pasted in, so the quotes matter.
+		words, err := shellWords(line)
+		if err != nil {
+			fmt.Printf("%s\n", t.Error(err))
+			return nil
+		}
+		rootCmd.SetArgs(append([]string{"http", "curl"}, words...)) // Not ParseFlags, curl's flags aren't ours.
+		return rootCmd.Execute()
+	}
 	rootCmd.ParseFlags(args)
+	buildAPIOperations(args)
 	rootCmd.SetArgs(args)
 	err = rootCmd.Execute()
 
@@ -110,9 +122,13 @@ func promptLoop(process func(string) error) (err error) {
 	for moreCommands := true; moreCommands; {
 		serviceURL := ""
 		connName := ""
+		insecure := ""
 		if conn, err := connection.GetCurrentConnection(); err == nil {
 			serviceURL = conn.ServiceURL
 			connName = conn.Name
+			if isInsecure(conn) {
+				insecure = t.Alert("INSECURE ")
+			}
 		}
 		// token := conn.getSafeToken(true, false)
 		token := ""
@@ -121,14 +137,20 @@ func promptLoop(process func(string) error) (err error) {
 			spacer = " "
 		}
 		status := statusDisplay()
-		prompt := fmt.Sprintf("%s [%s%s %s]: ",
-			t.Title(config.AppName), t.Info(status), t.Highlight(connName),
+		prompt := fmt.Sprintf("%s [%s%s%s %s]: ",
+			t.Title(config.AppName), t.Info(status), insecure, t.Highlight(connName),
 			t.SubTitle("%s%s%s", serviceURL, spacer, token))
 
 		if config.Debug() {
 			fmt.Println() // add a stanza mark between the spew.
 		}
 		line, err := readline.Line(prompt)
+		for err == nil && isCurlLine(line) && strings.HasSuffix(strings.TrimRight(line, " \t"), `\`) {
+			var more string // A pasted curl command that goes on.
+			if more, err = readline.Line("> "); err == nil {
+				line = strings.TrimRight(line, " \t") + "\n" + more
+			}
+		}
 		if err == io.EOF {
 			moreCommands = false
 		} else if err != nil {
@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	t "github.com/jdrivas/termtext"
+	"github.com/juju/ansiterm"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+/*
+JSON tables
+
+A response that's a JSON array of objects is displayed as a table, a row for each
+object. The columns are the keys of the objects, in the order they show up,
+or whatever you ask for with --columns. Columns can be paths into the
+objects (address.city, tags[0]).
+
+Tables are fit to the terminal by truncating the widest columns, unless you ask
+for --wide. Anything that isn't an array of objects is displayed as pretty JSON,
+same as always.
+*/
+
+// Table sizing.
+const (
+	defaultTerminalWidth = 120
+	minColumnWidth       = 8
+	columnPadding        = 2
+	truncationMark       = "..."
+)
+
+// jsonTable is the display of an array of objects.
+type jsonTable struct {
+	Columns []string
+	Rows    [][]string
+}
+
+// responseTable returns a table for the response body, or nil if the body doesn't make one.
+// The body is left in place for anyone else that wants to read it.
+func responseTable(resp *http.Response) *jsonTable {
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	tbl, err := newJSONTable(body, columnsFlag, sortFlag)
+	if err != nil {
+		return nil
+	}
+	return tbl
+}
+
+var errNotTable = errors.New("not an array of objects")
+
+func newJSONTable(body []byte, columns []string, sortBy string) (*jsonTable, error) {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(body, &raws); err != nil || len(raws) == 0 {
+		return nil, errNotTable
+	}
+
+	// Objects, and their keys in the order they're written.
+	objs := make([]map[string]interface{}, len(raws))
+	inferred := len(columns) == 0
+	seen := map[string]bool{}
+	for i, raw := range raws {
+		d := json.NewDecoder(bytes.NewReader(raw))
+		d.UseNumber()
+		if err := d.Decode(&objs[i]); err != nil || objs[i] == nil {
+			return nil, errNotTable
+		}
+		if inferred {
+			for _, k := range objectKeys(raw) {
+				if !seen[k] {
+					seen[k] = true
+					columns = append(columns, k)
+				}
+			}
+		}
+	}
+
+	if sortBy != "" {
+		desc := strings.HasPrefix(sortBy, "-")
+		key := strings.TrimPrefix(sortBy, "-")
+		sort.SliceStable(objs, func(i, j int) bool {
+			a, _ := lookupPath(objs[i], key)
+			b, _ := lookupPath(objs[j], key)
+			if desc {
+				return lessJSON(b, a)
+			}
+			return lessJSON(a, b)
+		})
+	}
+
+	tbl := &jsonTable{Columns: columns}
+	for _, o := range objs {
+		row := make([]string, len(columns))
+		for i, c := range columns {
+			v, err := lookupPath(o, c)
+			switch {
+			case err != nil:
+				row[i] = ""
+			case v == nil:
+				row[i] = "-"
+			default:
+				row[i] = jsonValueString(v)
+			}
+		}
+		tbl.Rows = append(tbl.Rows, row)
+	}
+	return tbl, nil
+}
+
+// objectKeys returns the top level keys of a JSON object in order.
+func objectKeys(raw json.RawMessage) (keys []string) {
+	d := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := d.Token(); err != nil || tok != json.Delim('{') {
+		return nil
+	}
+	for d.More() {
+		tok, err := d.Token()
+		if err != nil {
+			return keys
+		}
+		keys = append(keys, fmt.Sprintf("%v", tok))
+		var skip json.RawMessage
+		if err := d.Decode(&skip); err != nil {
+			return keys
+		}
+	}
+	return keys
+}
+
+// lessJSON orders numbers as numbers and everything else as text, missing values first.
+func lessJSON(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b != nil
+	}
+	if an, ok := a.(json.Number); ok {
+		if bn, ok := b.(json.Number); ok {
+			af, aerr := strconv.ParseFloat(string(an), 64)
+			bf, berr := strconv.ParseFloat(string(bn), 64)
+			if aerr == nil && berr == nil {
+				return af < bf
+			}
+		}
+	}
+	return jsonValueString(a) < jsonValueString(b)
+}
+
+// List prints the table, truncated to fit the terminal.
+func (tbl *jsonTable) List() {
+	widths := make([]int, len(tbl.Columns))
+	for i, c := range tbl.Columns {
+		widths[i] = utf8.RuneCountInString(c)
+	}
+	for _, r := range tbl.Rows {
+		for i, cell := range r {
+			widths[i] = maxInt(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+	if !wideFlag {
+		fitColumns(widths, terminalWidth())
+	}
+
+	w := ansiterm.NewTabWriter(os.Stdout, 4, 4, columnPadding, ' ', 0)
+	header := make([]string, len(tbl.Columns))
+	for i, c := range tbl.Columns {
+		header[i] = truncate(c, widths[i])
+	}
+	fmt.Fprintf(w, "%s\n", t.Title("%s", strings.Join(header, "\t")))
+	for _, r := range tbl.Rows {
+		cells := make([]string, len(r))
+		for i, cell := range r {
+			cells[i] = truncate(cell, widths[i])
+		}
+		fmt.Fprintf(w, "%s\n", t.Text("%s", strings.Join(cells, "\t")))
+	}
+	w.Flush()
+}
+
+// fitColumns narrows the widest columns until the table fits in width.
+func fitColumns(widths []int, width int) {
+	total := func() (n int) {
+		for _, w := range widths {
+			n += w + columnPadding
+		}
+		return n
+	}
+	for total() > width {
+		widest := 0
+		for i := range widths {
+			if widths[i] > widths[widest] {
+				widest = i
+			}
+		}
+		if widths[widest] <= minColumnWidth {
+			return
+		}
+		widths[widest]--
+	}
+}
+
+func truncate(s string, width int) string {
+	s = strings.Replace(s, "\n", " ", -1)
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	r := []rune(s)
+	return string(r[:width-len(truncationMark)]) + truncationMark
+}
+
+// terminalWidth is the width of the terminal, or $COLUMNS, or a guess.
+func terminalWidth() int {
+	if w, _, err := terminal.GetSize(int(os.Stdout.Fd())); err == nil && w > 0 {
+		return w
+	}
+	if w, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && w > 0 {
+		return w
+	}
+	return defaultTerminalWidth
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// lookupPath finds the value at path in a decoded JSON document.
+// Paths are the simple JSONPath subset: $.users[0].name, users.0.name
+// and $["odd key"] all work. No wildcards, filters or slices.
+func lookupPath(doc interface{}, path string) (interface{}, error) {
+	segs, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+	v := doc
+	for _, s := range segs {
+		switch c := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = c[s]; !ok {
+				return nil, fmt.Errorf("no %q in %s", s, path)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, fmt.Errorf("%q isn't an array index in %s", s, path)
+			}
+			if i < 0 {
+				i += len(c)
+			}
+			if i < 0 || i >= len(c) {
+				return nil, fmt.Errorf("index %s out of range in %s", s, path)
+			}
+			v = c[i]
+		default:
+			return nil, fmt.Errorf("can't look up %q in a %s in %s", s, jsonTypeName(v), path)
+		}
+	}
+	return v, nil
+}
+
+// splitPath breaks a path into its keys and indexes.
+func splitPath(path string) (segs []string, err error) {
+	p := strings.TrimPrefix(strings.TrimSpace(path), "$")
+	for len(p) > 0 {
+		switch p[0] {
+		case '.':
+			p = p[1:]
+		case '[':
+			end := strings.Index(p, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("missing ] in %s", path)
+			}
+			s := p[1:end]
+			if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+				s = s[1 : len(s)-1]
+			}
+			segs = append(segs, s)
+			p = p[end+1:]
+		default:
+			end := strings.IndexAny(p, ".[")
+			if end < 0 {
+				end = len(p)
+			}
+			segs = append(segs, p[:end])
+			p = p[end:]
+		}
+	}
+	return segs, nil
+}
+
+func jsonTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	}
+	return "number"
+}
+
+// jsonValueString is v as you'd want to paste it into a path or a body:
+// strings as is, everything else as JSON.
+func jsonValueString(v interface{}) string {
+	switch c := v.(type) {
+	case string:
+		return c
+	case nil:
+		return "null"
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// decodeJSON is json.Unmarshal into an interface{}, but keeps numbers as
+// json.Numbers so big integer ids don't come back as floats.
+func decodeJSON(b []byte) (doc interface{}, err error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	err = d.Decode(&doc)
+	return doc, err
+}
@@ -0,0 +1,526 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // Register hashes for crypto.Hash.New
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	connection "github.com/jdrivas/conman"
+	t "github.com/jdrivas/termtext"
+	config "github.com/jdrivas/vconfig"
+	"github.com/juju/ansiterm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Token arguments that aren't tokens.
+const (
+	jwtLastArg       = "last"       // The last JWT seen in a response.
+	jwtConnectionArg = "connection" // The current connection's auth token.
+)
+
+// JWKS location on the service, relative to the service URL.
+// Set per connection with jwksPath.
+const (
+	jwksPathKey        = "jwksPath" // string
+	defaultJWKSPath    = "/.well-known/jwks.json"
+	jwtExpiryWarning   = 5 * time.Minute
+	jwtTokenArgs       = "<token>|" + jwtLastArg + "|" + jwtConnectionArg
+	jwtTimeClaimFormat = time.RFC1123
+)
+
+var jwtCmd *cobra.Command
+
+func buildJWT(mode runMode) {
+
+	jwtCmd = &cobra.Command{
+		Use:   "jwt",
+		Short: "Inspect JSON Web Tokens.",
+		Long:  "Decode and verify JSON Web Tokens without pasting them into a website.",
+	}
+	rootCmd.AddCommand(jwtCmd)
+	initJWTFlags()
+
+	jwtCmd.AddCommand(&cobra.Command{
+		Use:   fmt.Sprintf("decode %s", jwtTokenArgs),
+		Short: "Display the header and claims of a JWT.",
+		Long: fmt.Sprintf(`Pretty prints the header and claims of a JWT, with times in human readable form
+and a warning if the token has expired or is about to.
+
+Use %q for the last token seen in a response and %q for the
+auth token of the current connection.`, jwtLastArg, jwtConnectionArg),
+		Example: fmt.Sprintf("%s jwt decode %s", config.AppName, jwtConnectionArg),
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			tok, err := jwtFromArg(args[0])
+			switch {
+			case err != nil:
+				fmt.Printf("%s\n", t.Error(err))
+			default:
+				printOutput(tok.view(), tok.Describe)
+			}
+		},
+	})
+
+	jwtCmd.AddCommand(&cobra.Command{
+		Use:   fmt.Sprintf("verify [flags] %s", jwtTokenArgs),
+		Short: "Verify the signature and times of a JWT.",
+		Long: fmt.Sprintf(`Checks the signature of the JWT against a JWKS, and that the token is
+inside its validity period.
+
+The JWKS is fetched from the current connection at the connection's jwksPath
+(default %s) or read from a local file with --jwks.
+HMAC signed tokens need the --secret.`, defaultJWKSPath),
+		Example: fmt.Sprintf("%s jwt verify --jwks keys.json %s", config.AppName, jwtLastArg),
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			tok, err := jwtFromArg(args[0])
+			if err != nil {
+				fmt.Printf("%s\n", t.Error(err))
+				return
+			}
+			src, sigErr := tok.verify()
+			timeErr := tok.checkTimes(time.Now())
+			v := tok.view()
+			v["signature"], v["validity"] = newJWTCheck(src, sigErr), newJWTCheck("", timeErr)
+			printOutput(v, func() {
+				tok.Describe()
+				if sigErr == nil {
+					fmt.Printf("%s %s\n", t.Title("Signature:"), t.Success("verified with %s", src))
+				} else {
+					fmt.Printf("%s %s\n", t.Title("Signature:"), t.Fail("%s", sigErr.Error()))
+				}
+				if timeErr == nil {
+					fmt.Printf("%s %s\n", t.Title("Validity:"), t.Success("valid"))
+				} else {
+					fmt.Printf("%s %s\n", t.Title("Validity:"), t.Fail("%s", timeErr.Error()))
+				}
+			})
+		},
+	})
+}
+
+// JWT command flags
+//
+
+var (
+	jwksFlag      string
+	jwtSecretFlag string
+)
+
+const (
+	jwksFlagKey      = "jwks"
+	jwtSecretFlagKey = "secret"
+)
+
+func initJWTFlags() {
+	jwtCmd.PersistentFlags().StringVar(&jwksFlag, jwksFlagKey, "",
+		"JWKS file to verify with, instead of fetching it from the connection.")
+	jwtCmd.PersistentFlags().StringVar(&jwtSecretFlag, jwtSecretFlagKey, "",
+		"Shared secret for verifying HMAC (HS256 etc.) signed tokens.")
+}
+
+// Tokens
+//
+
+type jwtToken struct {
+	Raw       string
+	Header    map[string]interface{}
+	Claims    map[string]interface{}
+	signed    string // header.claims, what the signature covers.
+	signature []byte
+}
+
+func jwtFromArg(arg string) (*jwtToken, error) {
+	switch arg {
+	case jwtLastArg:
+		if s := getLastJWT(); s != "" {
+			return parseJWT(s)
+		}
+		return nil, errors.New("haven't seen a JWT in a response yet")
+	case jwtConnectionArg:
+		conn, err := connection.GetCurrentConnection()
+		if err != nil {
+			return nil, err
+		}
+		if conn.AuthToken == "" {
+			return nil, fmt.Errorf("connection %q doesn't have an auth token", conn.Name)
+		}
+		return parseJWT(conn.AuthToken)
+	}
+	return parseJWT(arg)
+}
+
+func parseJWT(s string) (tok *jwtToken, err error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(strings.ToLower(s), "bearer ") {
+		s = strings.TrimSpace(s[len("bearer "):])
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("a JWT has 3 parts, found %d", len(parts))
+	}
+
+	tok = &jwtToken{Raw: s, signed: parts[0] + "." + parts[1]}
+	if tok.Header, err = decodeJWTPart(parts[0]); err != nil {
+		return nil, fmt.Errorf("bad JWT header: %v", err)
+	}
+	if tok.Claims, err = decodeJWTPart(parts[1]); err != nil {
+		return nil, fmt.Errorf("bad JWT claims: %v", err)
+	}
+	if tok.signature, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[2], "=")); err != nil {
+		return nil, fmt.Errorf("bad JWT signature: %v", err)
+	}
+	return tok, nil
+}
+
+func decodeJWTPart(p string) (m map[string]interface{}, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(p, "="))
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber() // Keep the times as integers.
+	err = d.Decode(&m)
+	return m, err
+}
+
+func claimString(m map[string]interface{}, k string) string {
+	s, _ := m[k].(string)
+	return s
+}
+
+// Time claims are NumericDate: seconds since the epoch.
+var jwtTimeClaims = map[string]bool{"exp": true, "nbf": true, "iat": true, "auth_time": true}
+
+func (tok *jwtToken) timeClaim(k string) (time.Time, bool) {
+	if n, ok := tok.Claims[k].(json.Number); ok {
+		if s, err := n.Float64(); err == nil {
+			return time.Unix(int64(s), 0), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// checkTimes makes sure now is inside of nbf and exp.
+func (tok *jwtToken) checkTimes(now time.Time) error {
+	if exp, ok := tok.timeClaim("exp"); ok && !now.Before(exp) {
+		return fmt.Errorf("expired %s ago", relativeTime(now.Sub(exp)))
+	}
+	if nbf, ok := tok.timeClaim("nbf"); ok && now.Before(nbf) {
+		return fmt.Errorf("not valid for another %s", relativeTime(nbf.Sub(now)))
+	}
+	return nil
+}
+
+// Verification
+//
+
+// verify checks the signature and returns a description of the key that verified it.
+func (tok *jwtToken) verify() (source string, err error) {
+	alg := claimString(tok.Header, "alg")
+	if strings.HasPrefix(alg, "HS") {
+		if jwtSecretFlag == "" {
+			return "", fmt.Errorf("%s tokens need --%s to verify", alg, jwtSecretFlagKey)
+		}
+		return "shared secret", verifyJWTSignature(alg, []byte(jwtSecretFlag), []byte(tok.signed), tok.signature)
+	}
+	if alg == "" || alg == "none" {
+		return "", fmt.Errorf("token is unsigned (alg %q)", alg)
+	}
+
+	keys, source, err := getJWKS()
+	if err != nil {
+		return "", err
+	}
+
+	kid := claimString(tok.Header, "kid")
+	tried := 0
+	for _, k := range keys.Keys {
+		if kid != "" && k.Kid != kid {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		tried++
+		if verifyJWTSignature(alg, pub, []byte(tok.signed), tok.signature) == nil {
+			return fmt.Sprintf("key %q from %s", k.Kid, source), nil
+		}
+	}
+	if tried == 0 {
+		return "", fmt.Errorf("no usable key for kid %q in %s", kid, source)
+	}
+	return "", fmt.Errorf("signature doesn't match any key in %s", source)
+}
+
+var jwtHashes = map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+
+func verifyJWTSignature(alg string, key interface{}, signed, sig []byte) error {
+	if alg == "EdDSA" {
+		if k, ok := key.(ed25519.PublicKey); ok && ed25519.Verify(k, signed, sig) {
+			return nil
+		}
+		return errors.New("signature doesn't match")
+	}
+
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	hash, ok := jwtHashes[alg[2:]]
+	if !ok {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case []byte:
+		if alg[:2] == "HS" {
+			m := hmac.New(hash.New, k)
+			m.Write(signed)
+			if hmac.Equal(m.Sum(nil), sig) {
+				return nil
+			}
+			return errors.New("signature doesn't match")
+		}
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(k, hash, digest, sig)
+		case "PS":
+			return rsa.VerifyPSS(k, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
+		}
+	case *ecdsa.PublicKey:
+		if alg[:2] == "ES" {
+			n := len(sig) / 2
+			r, s := new(big.Int).SetBytes(sig[:n]), new(big.Int).SetBytes(sig[n:])
+			if ecdsa.Verify(k, digest, r, s) {
+				return nil
+			}
+			return errors.New("signature doesn't match")
+		}
+	}
+	return fmt.Errorf("key type %T doesn't work with %s", key, alg)
+}
+
+// JWKS
+//
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+// getJWKS reads the --jwks file, or fetches from the current connection.
+func getJWKS() (keys jwkSet, source string, err error) {
+	if jwksFlag != "" {
+		b, err := ioutil.ReadFile(expandHome(jwksFlag))
+		if err == nil {
+			err = json.Unmarshal(b, &keys)
+		}
+		return keys, jwksFlag, err
+	}
+
+	conn, err := connection.GetCurrentConnection()
+	if err != nil {
+		return keys, "", err
+	}
+	path := viper.GetString(connectionKey(conn.Name, jwksPathKey))
+	if path == "" {
+		path = defaultJWKSPath
+	}
+	_, resp, err := conn.Get(path, &keys)
+	if resp != nil && resp.Body != nil {
+		resp.Body.Close()
+	}
+	return keys, conn.ServiceURL + path, err
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	dec := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+		return new(big.Int).SetBytes(b), err
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := dec(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := dec(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := dec(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := dec(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k.X, "="))
+		return ed25519.PublicKey(b), err
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// Last JWT
+// The transport notes JWTs it sees in responses, so "last" can find them.
+//
+
+var (
+	lastJWT   string
+	lastJWTMu sync.Mutex
+)
+
+var jwtPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+
+func noteResponseJWT(resp *http.Response, body []byte) {
+	found := jwtPattern.Find(body)
+	if found == nil {
+	headers:
+		for _, vs := range resp.Header {
+			for _, v := range vs {
+				if found = jwtPattern.Find([]byte(v)); found != nil {
+					break headers
+				}
+			}
+		}
+	}
+	if found != nil {
+		lastJWTMu.Lock()
+		lastJWT = string(found)
+		lastJWTMu.Unlock()
+	}
+}
+
+func getLastJWT() string {
+	lastJWTMu.Lock()
+	defer lastJWTMu.Unlock()
+	return lastJWT
+}
+
+// Display
+//
+
+// Describe prints the header and claims.
+func (tok *jwtToken) Describe() {
+	w := ansiterm.NewTabWriter(os.Stdout, 4, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\n", t.Title("Header\t"))
+	for _, k := range sortedKeys(tok.Header) {
+		fmt.Fprintf(w, "%s\t%s\n", t.Title("  %s:", k), t.Text("%v", tok.Header[k]))
+	}
+	fmt.Fprintf(w, "%s\n", t.Title("Claims\t"))
+	now := time.Now()
+	for _, k := range sortedKeys(tok.Claims) {
+		v := t.Text("%v", tok.Claims[k])
+		if tm, ok := tok.timeClaim(k); ok && jwtTimeClaims[k] {
+			v = t.Text("%s (%s)", tm.Local().Format(jwtTimeClaimFormat), relativeTo(now, tm))
+		}
+		fmt.Fprintf(w, "%s\t%s\n", t.Title("  %s:", k), v)
+	}
+	w.Flush()
+
+	if exp, ok := tok.timeClaim("exp"); ok {
+		switch {
+		case !now.Before(exp):
+			fmt.Printf("%s\n", t.Fail("Token expired %s ago.", relativeTime(now.Sub(exp))))
+		case exp.Sub(now) < jwtExpiryWarning:
+			fmt.Printf("%s\n", t.Warn("Token expires in %s.", relativeTime(exp.Sub(now))))
+		}
+	}
+}
+
+// view is the token for the non-table output formats.
+// jwtCheck is how one of verify's checks came out.
+type jwtCheck struct {
+	Passed bool   `json:"passed"`
+	With   string `json:"with,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func newJWTCheck(with string, err error) jwtCheck {
+	if err != nil {
+		return jwtCheck{Error: err.Error()}
+	}
+	return jwtCheck{Passed: true, With: with}
+}
+
+func (tok *jwtToken) view() map[string]interface{} {
+	return map[string]interface{}{
+		"header": tok.Header,
+		"claims": tok.Claims,
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// relativeTo says how far tm is from now, e.g. "in 5m0s" or "2h0m0s ago"
+func relativeTo(now, tm time.Time) string {
+	if tm.After(now) {
+		return "in " + relativeTime(tm.Sub(now))
+	}
+	return relativeTime(now.Sub(tm)) + " ago"
+}
+
+func relativeTime(d time.Duration) string {
+	return d.Round(time.Second).String()
+}
@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	t "github.com/jdrivas/termtext"
+	config "github.com/jdrivas/vconfig"
+	"github.com/juju/ansiterm"
+	"github.com/spf13/cobra"
+)
+
+/*
+Recent Responses
+
+httpDisplay keeps the last few responses it shows, for show last, and for --save
+and --save-header, which take values out of the response into variables
+(see vars.go) so the next request can use them:
+
+	gafw http post /login @creds.json --save token=$.access_token
+	gafw http get /me --save-header etag=ETag
+
+	interactive> http get /users/${id}
+
+The saves happen before --query changes the body. They're like set var, they last
+the session, and they're only made from successful responses, not errors or 4xx and
+5xx statuses.
+*/
+
+const recentSize = 20
+
+type recentResponse struct {
+	Time    time.Time   `json:"time"`
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Status  string      `json:"status"`
+	Headers http.Header `json:"headers"`
+	Body    []byte      `json:"-"`
+	code    int
+}
+
+type recentResponses struct {
+	mu        sync.Mutex
+	responses []*recentResponse
+}
+
+var recent = &recentResponses{}
+
+func (r *recentResponses) keep(resp *http.Response) *recentResponse {
+	body, _ := responseBody(resp)
+	rr := &recentResponse{Time: time.Now(), Status: resp.Status, code: resp.StatusCode, Headers: resp.Header.Clone(), Body: body}
+	if resp.Request != nil {
+		rr.Method, rr.URL = resp.Request.Method, resp.Request.URL.String()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.responses = append(r.responses, rr)
+	if len(r.responses) > recentSize {
+		r.responses = r.responses[len(r.responses)-recentSize:]
+	}
+	return rr
+}
+
+func (r *recentResponses) last() *recentResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.responses) == 0 {
+		return nil
+	}
+	return r.responses[len(r.responses)-1]
+}
+
+func buildLast(mode runMode) {
+	showCmd.AddCommand(&cobra.Command{
+		Use:       "last [headers|body]",
+		Short:     "Show the last response.",
+		Long:      "Show the last response, or just its headers or its body.",
+		Args:      cobra.OnlyValidArgs,
+		ValidArgs: []string{"headers", "body"},
+		Run: func(cmd *cobra.Command, args []string) {
+			rr := recent.last()
+			if rr == nil {
+				fmt.Printf("%s\n", t.Error(errors.New("there haven't been any responses this session")))
+				return
+			}
+			part := ""
+			if len(args) > 0 {
+				part = args[0]
+			}
+			showLast(rr, part)
+		},
+	})
+}
+
+// HTTP save flags
+// These are on the http command with the rest, see initHTTPFlags.
+
+var (
+	saveFlag       []string
+	saveHeaderFlag []string
+)
+
+const (
+	saveFlagKey       = "save"
+	saveHeaderFlagKey = "save-header"
+)
+
+// saveFromResponse sets the variables asked for with --save and --save-header.
+func saveFromResponse(rr *recentResponse, err error) {
+	if len(saveFlag) == 0 && len(saveHeaderFlag) == 0 {
+		return
+	}
+	if err != nil || rr.code >= 400 {
+		fmt.Printf("%s\n", t.Warn("Nothing saved, the request didn't succeed."))
+		return
+	}
+	var doc interface{}
+	var docErr error
+	if len(saveFlag) > 0 {
+		doc, docErr = decodeJSON(rr.Body)
+	}
+
+	save := func(flag, spec string, value func(from string) (string, error)) {
+		i := strings.Index(spec, "=")
+		if i <= 0 || !varRE.MatchString("${"+spec[:i]+"}") {
+			fmt.Printf("%s\n", t.Error(fmt.Errorf("--%s %q isn't <name>=<from>", flag, spec)))
+			return
+		}
+		name, from := strings.ToLower(spec[:i]), spec[i+1:]
+		v, err := value(from)
+		if err != nil {
+			fmt.Printf("%s\n", t.Error(fmt.Errorf("--%s %s: %v", flag, spec[:i], err)))
+			return
+		}
+		config.Set(varsKey+"."+name, v)
+		if tableOutputFormat() {
+			fmt.Printf("%s\n", t.Info("Saved %s = %s", name, v))
+		}
+	}
+	for _, spec := range saveFlag {
+		save(saveFlagKey, spec, func(from string) (string, error) {
+			if docErr != nil {
+				return "", fmt.Errorf("the response isn't JSON: %v", docErr)
+			}
+			v, err := lookupPath(doc, from)
+			if err != nil {
+				return "", err
+			}
+			return jsonValueString(v), nil
+		})
+	}
+	for _, spec := range saveHeaderFlag {
+		save(saveHeaderFlagKey, spec, func(from string) (string, error) {
+			if v := rr.Headers.Get(from); v != "" {
+				return v, nil
+			}
+			return "", fmt.Errorf("there's no %s header", from)
+		})
+	}
+}
+
+// Display
+//
+
+func showLast(rr *recentResponse, part string) {
+	var body interface{} = string(rr.Body)
+	if doc, err := decodeJSON(rr.Body); err == nil {
+		body = doc
+	}
+	switch part {
+	case "headers":
+		printOutput(rr.Headers, func() { displayLastHeaders(rr.Headers) })
+	case "body":
+		printOutput(body, func() { displayLastBody(rr.Body) })
+	default:
+		v := struct {
+			*recentResponse
+			Body interface{} `json:"body,omitempty"`
+		}{rr, body}
+		printOutput(v, func() {
+			fmt.Printf("%s %s\n", t.Title("%s %s", rr.Method, rr.URL), t.SubTitle("%s", rr.Time.Format("15:04:05")))
+			fmt.Printf("%s %s\n", t.Title("Status:"), t.Text("%s", rr.Status))
+			displayLastHeaders(rr.Headers)
+			displayLastBody(rr.Body)
+		})
+	}
+}
+
+func displayLastHeaders(h http.Header) {
+	keys := []string{}
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	w := ansiterm.NewTabWriter(os.Stdout, 4, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\n", t.Title("Header\tValue"))
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s\n", t.Text("%s\t%s", k, strings.Join(h[k], ", ")))
+	}
+	w.Flush()
+}
+
+func displayLastBody(body []byte) {
+	if len(body) == 0 {
+		return
+	}
+	var b bytes.Buffer
+	if json.Indent(&b, body, "", "  ") != nil {
+		b.Reset()
+		b.Write(body)
+	}
+	fmt.Printf("%s\n", t.Text("%s", strings.TrimRight(b.String(), "\n")))
+}
@@ -0,0 +1,570 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	connection "github.com/jdrivas/conman"
+	t "github.com/jdrivas/termtext"
+	config "github.com/jdrivas/vconfig"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+/*
+Mock Server
+
+serve mock stands up a local server that answers every operation in an OpenAPI spec:
+
+	gafw serve mock --spec api.yaml --port 8080
+
+Responses come from the spec's examples: the media type's example, or the first of
+its examples, or the schema's example. If there aren't any, one is made up from
+the schema. The status is the operation's first 2xx, or whatever the request asks
+for with Prefer: code=404 (and Prefer: example=<name> picks an example).
+
+Requests are checked against the spec first, parameters and body, and get a 400
+with the problems if they don't match. Requests for operations that aren't in the
+spec get a 404. CORS is wide open so a front end can use it from anywhere.
+
+The server is added to the session as a connection (mock, or --name), with the spec,
+so the http and api commands work against it. From the command line it runs until
+you interrupt it. In interactive mode it runs in the background for the rest of the
+session, and only logs requests if verbose mode was on when it started.
+
+--spec defaults to the current connection's spec.
+*/
+
+var mockCmd *cobra.Command
+
+func buildMock(mode runMode) {
+	mockCmd = &cobra.Command{
+		Use:   "mock [flags]",
+		Short: "Serve a mock of an OpenAPI spec.",
+		Long: `Run a local server that answers the operations in an OpenAPI spec with their
+examples, or data made up from their schemas. Requests are checked against the spec.
+The server is added as a connection for the session.`,
+		Example: fmt.Sprintf("  %s serve mock --spec api.yaml --port 8080", config.AppName),
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			serveMock()
+		},
+	}
+	serveCmd.AddCommand(mockCmd)
+	initMockFlags()
+}
+
+// Mock flags
+//
+
+var (
+	mockSpecFlag string
+	mockPortFlag int
+	mockHostFlag string
+	mockNameFlag string
+)
+
+const (
+	mockSpecFlagKey = "spec"
+	mockPortFlagKey = "port"
+	mockHostFlagKey = "host"
+	mockNameFlagKey = "name"
+)
+
+func initMockFlags() {
+	mockCmd.Flags().StringVar(&mockSpecFlag, mockSpecFlagKey, "", "OpenAPI spec file or URL to mock (default the current connection's).")
+	mockCmd.Flags().IntVar(&mockPortFlag, mockPortFlagKey, 8080, "Port to listen on.")
+	mockCmd.Flags().StringVar(&mockHostFlag, mockHostFlagKey, "127.0.0.1", "Address to listen on.")
+	mockCmd.Flags().StringVar(&mockNameFlag, mockNameFlagKey, "mock", "Name of the connection for the mock server.")
+}
+
+// Set when we're running interactive, so servers can run in the background.
+var interactiveSession bool
+
+func serveMock() {
+	spec, doc, err := mockSpec(mockSpecFlag)
+	if err != nil {
+		fmt.Printf("%s\n", t.Error(err))
+		return
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(mockHostFlag, strconv.Itoa(mockPortFlag)))
+	if err != nil {
+		fmt.Printf("%s\n", t.Error(err))
+		return
+	}
+	serviceURL := "http://" + l.Addr().String()
+	addSessionConnection(mockNameFlag, map[string]interface{}{
+		strings.ToLower(connection.ServiceURLKey): serviceURL,
+		openAPIKey: spec,
+	})
+
+	// Decided now: the handlers run alongside the prompt, and viper isn't safe to read while it sets things.
+	m := &mockServer{doc: doc, log: !interactiveSession || config.Verbose()}
+	background := interactiveSession
+	srv := &http.Server{Handler: m}
+	fmt.Printf("%s\n", t.Title("Mocking %s %s at %s, connection %s.", doc.Info.Title, doc.Info.Version, serviceURL, mockNameFlag))
+
+	if background {
+		go srv.Serve(l)
+		fmt.Printf("%s\n", t.Info("It'll run until the session ends: set connection %s, or -c %s.", mockNameFlag, mockNameFlag))
+		return
+	}
+	fmt.Printf("%s\n", t.Info("Interrupt to stop. To keep the connection, add it to your config:"))
+	fmt.Printf("%s\n", t.Text("  connections:\n    %s:\n      serviceURL: %s\n      openapi: %s", mockNameFlag, serviceURL, spec))
+	stopOnInterrupt(srv)
+	if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+		fmt.Printf("%s\n", t.Error(err))
+	}
+}
+
+// stopOnInterrupt shuts the server down on ^C.
+func stopOnInterrupt(srv *http.Server) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		signal.Stop(sig)
+		srv.Shutdown(context.Background())
+	}()
+}
+
+// mockSpec reads the spec from a file or URL, or the current connection's.
+// Files get an absolute path, so the connection works from anywhere.
+func mockSpec(spec string) (string, *openAPIDoc, error) {
+	if spec == "" {
+		conn, err := connection.GetCurrentConnection()
+		if err != nil {
+			return "", nil, err
+		}
+		spec = viper.GetString(connectionKey(conn.Name, openAPIKey))
+		if spec == "" {
+			return "", nil, fmt.Errorf("use --%s, connection %q doesn't have a spec", mockSpecFlagKey, conn.Name)
+		}
+		if strings.HasPrefix(spec, "/") {
+			if _, err := os.Stat(expandHome(spec)); err != nil {
+				spec = strings.TrimSuffix(conn.ServiceURL, "/") + spec // It's on the service.
+			}
+		}
+		doc, err := connectionOpenAPI(conn)
+		return spec, doc, err
+	}
+
+	if !strings.HasPrefix(spec, "http://") && !strings.HasPrefix(spec, "https://") {
+		fn, err := filepath.Abs(expandHome(spec))
+		if err != nil {
+			return "", nil, err
+		}
+		if _, err := os.Stat(fn); err != nil {
+			return "", nil, err
+		}
+		spec = fn
+	}
+	b, err := readOpenAPI(nil, spec)
+	if err != nil {
+		return "", nil, err
+	}
+	doc, err := parseOpenAPI(b)
+	if err != nil {
+		return "", nil, fmt.Errorf("OpenAPI spec %s: %v", spec, err)
+	}
+	return spec, doc, nil
+}
+
+// addSessionConnection adds a connection that lasts as long as we do.
+// All of the connections get set, setting just the one would hide the rest from viper.
+func addSessionConnection(name string, settings map[string]interface{}) {
+	conns := viper.GetStringMap(connection.ConnectionsKey)
+	conns[strings.ToLower(name)] = settings
+	viper.Set(connection.ConnectionsKey, conns)
+}
+
+// Serving
+//
+
+type mockServer struct {
+	doc *openAPIDoc
+	log bool
+}
+
+func (m *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	op, params := m.doc.findOperation(r.Method, r.URL.Path)
+	if op == nil && r.Method == http.MethodOptions { // A CORS preflight, not a real OPTIONS.
+		w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, POST, DELETE, PATCH, HEAD, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+		w.WriteHeader(http.StatusNoContent)
+		m.logRequest(r, http.StatusNoContent, "preflight", nil)
+		return
+	}
+	if op == nil {
+		m.reply(w, r, http.StatusNotFound, "", map[string]interface{}{
+			"message": fmt.Sprintf("%s %s isn't in the spec", r.Method, r.URL.Path),
+		}, nil)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		m.reply(w, r, http.StatusBadRequest, op.OperationID, map[string]interface{}{"message": err.Error()}, nil)
+		return
+	}
+	if problems := m.checkRequest(op, params, r, body); len(problems) > 0 {
+		m.reply(w, r, http.StatusBadRequest, op.OperationID, map[string]interface{}{
+			"message":  fmt.Sprintf("the request doesn't match the spec for %s", op.OperationID),
+			"problems": problems,
+		}, problems)
+		return
+	}
+
+	prefs := preferences(r)
+	status, reply := m.mockReply(op, prefs["code"])
+	reply, err = m.doc.resolveReply(reply)
+	if err != nil {
+		m.reply(w, r, http.StatusInternalServerError, op.OperationID, map[string]interface{}{"message": err.Error()}, nil)
+		return
+	}
+	if reply == nil || len(reply.Content) == 0 {
+		w.WriteHeader(status)
+		m.logRequest(r, status, op.OperationID, nil)
+		return
+	}
+	ct, b, err := m.mockBody(reply, prefs["example"])
+	if err != nil {
+		m.reply(w, r, http.StatusInternalServerError, op.OperationID, map[string]interface{}{"message": err.Error()}, nil)
+		return
+	}
+	w.Header().Set("Content-Type", ct)
+	w.WriteHeader(status)
+	w.Write(b)
+	m.logRequest(r, status, op.OperationID, nil)
+}
+
+// reply sends a JSON body of our own, for errors.
+func (m *mockServer) reply(w http.ResponseWriter, r *http.Request, status int, opID string, v interface{}, problems []string) {
+	b, _ := json.MarshalIndent(v, "", "  ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+	m.logRequest(r, status, opID, problems)
+}
+
+func (m *mockServer) logRequest(r *http.Request, status int, opID string, problems []string) {
+	if !m.log {
+		return
+	}
+	line := fmt.Sprintf("%s %s %d %s", r.Method, r.URL.RequestURI(), status, opID)
+	if status >= 400 {
+		fmt.Printf("%s\n", t.Warn("%s", line))
+	} else {
+		fmt.Printf("%s\n", t.Text("%s", line))
+	}
+	for _, p := range problems {
+		fmt.Printf("  %s\n", t.Text("%s", p))
+	}
+}
+
+// Requests
+//
+
+// checkRequest checks the parameters and body against the spec.
+func (m *mockServer) checkRequest(op *operation, pathParams map[string]string, r *http.Request, body []byte) (problems []string) {
+	params, err := m.doc.parameters(op)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	query := r.URL.Query()
+	for _, p := range params {
+		var raw []string
+		switch p.In {
+		case "path":
+			if v, ok := pathParams[p.Name]; ok {
+				raw = []string{v}
+			}
+		case "query":
+			raw = query[p.Name]
+		case "header":
+			raw = r.Header[http.CanonicalHeaderKey(p.Name)]
+		case "cookie":
+			if c, err := r.Cookie(p.Name); err == nil {
+				raw = []string{c.Value}
+			}
+		}
+		at := fmt.Sprintf("%s parameter %s", p.In, p.Name)
+		if len(raw) == 0 {
+			if p.Required || p.In == "path" {
+				problems = append(problems, at+": is required")
+			}
+			continue
+		}
+		s, err := m.doc.resolveSchema(p.Schema)
+		if err != nil {
+			problems = append(problems, at+": "+err.Error())
+			continue
+		}
+		problems = append(problems, m.doc.validateAt(s, m.paramValue(s, raw), at, true, 0)...)
+	}
+
+	if op.RequestBody == nil {
+		return problems
+	}
+	rb, err := m.doc.resolveBody(op.RequestBody)
+	if err != nil {
+		return append(problems, err.Error())
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if rb.Required {
+			problems = append(problems, "the body is required")
+		}
+		return problems
+	}
+	ct := r.Header.Get("Content-Type")
+	mt, key := matchContent(rb.Content, ct)
+	if mt == nil {
+		return append(problems, fmt.Sprintf("Content-Type %q isn't one of %s", ct, strings.Join(contentTypes(rb.Content), ", ")))
+	}
+	if !isJSONContent(key) || mt.Schema == nil {
+		return problems
+	}
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return append(problems, fmt.Sprintf("the body isn't JSON: %v", err))
+	}
+	return append(problems, m.doc.validate(mt.Schema, v, true)...)
+}
+
+// paramValue makes a JSON value out of a parameter's strings, so the schema can check it.
+// Arrays can be repeated or comma separated.
+func (m *mockServer) paramValue(s *schema, raw []string) interface{} {
+	if s == nil {
+		return raw[0]
+	}
+	if s.Type.name() != "array" {
+		return paramScalar(s.Type.name(), raw[0])
+	}
+	items, _ := m.doc.resolveSchema(s.Items)
+	typ := ""
+	if items != nil {
+		typ = items.Type.name()
+	}
+	vals := []interface{}{}
+	for _, r := range raw {
+		for _, v := range strings.Split(r, ",") {
+			vals = append(vals, paramScalar(typ, v))
+		}
+	}
+	return vals
+}
+
+// paramScalar is the value as its type if it is one, or a string for the schema to complain about.
+func paramScalar(typ, v string) interface{} {
+	switch typ {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(v, 64); err == nil {
+			return json.Number(v)
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return v
+}
+
+var preferRE = regexp.MustCompile(`(\w+)=("[^"]*"|[^,;\s]+)`)
+
+// preferences reads Prefer: code=404, example=empty.
+func preferences(r *http.Request) map[string]string {
+	prefs := map[string]string{}
+	for _, h := range r.Header["Prefer"] {
+		for _, m := range preferRE.FindAllStringSubmatch(h, -1) {
+			prefs[strings.ToLower(m[1])] = strings.Trim(m[2], `"`)
+		}
+	}
+	return prefs
+}
+
+// Responses
+//
+
+// mockReply picks the status to send: the preferred one if the operation has it,
+// otherwise the first success.
+func (m *mockServer) mockReply(op *operation, prefer string) (int, *openAPIReply) {
+	if code, err := strconv.Atoi(prefer); err == nil {
+		if r := op.reply(code); r != nil {
+			return code, r
+		}
+	}
+	codes := op.statuses()
+	for _, c := range codes {
+		if n, err := strconv.Atoi(c); err == nil && n >= 200 && n < 300 {
+			return n, op.Responses[c]
+		}
+	}
+	for _, c := range codes {
+		if strings.EqualFold(c, "2XX") || c == "default" {
+			return http.StatusOK, op.Responses[c]
+		}
+	}
+	for _, c := range codes {
+		if n, err := strconv.Atoi(c); err == nil {
+			return n, op.Responses[c]
+		}
+	}
+	return http.StatusNoContent, nil
+}
+
+// mockBody is the example for the response, or one made up from the schema.
+func (m *mockServer) mockBody(reply *openAPIReply, example string) (ct string, body []byte, err error) {
+	cts := contentTypes(reply.Content)
+	ct = cts[0]
+	for _, c := range cts {
+		if isJSONContent(c) {
+			ct = c
+			break
+		}
+	}
+	mt := reply.Content[ct]
+	if ct == "*/*" {
+		ct = "application/json"
+	}
+
+	var v interface{}
+	switch {
+	case example != "" && mt.Examples[example] != nil:
+		v = mt.Examples[example].Value
+	case mt.Example != nil:
+		v = mt.Example
+	case len(mt.Examples) > 0:
+		names := []string{}
+		for n := range mt.Examples {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		v = mt.Examples[names[0]].Value
+	default:
+		v = m.doc.exampleValue(mt.Schema, map[string]bool{})
+	}
+
+	if s, ok := v.(string); ok && !isJSONContent(ct) {
+		return ct, []byte(s), nil
+	}
+	body, err = json.MarshalIndent(v, "", "  ")
+	return ct, body, err
+}
+
+// exampleValue makes up a value for the schema: its example, default or first enum,
+// otherwise something of the right type and format.
+// refs are the $refs on the way here. A schema that refers back to one of them
+// (a Node with a parent and children that are Nodes) stops there with nil,
+// rather than making up a value for every path down the tree.
+func (doc *openAPIDoc) exampleValue(s *schema, refs map[string]bool) interface{} {
+	if s != nil && s.Ref != "" {
+		if refs[s.Ref] {
+			return nil
+		}
+		refs[s.Ref] = true
+		defer delete(refs, s.Ref)
+	}
+	s, err := doc.resolveSchema(s)
+	if err != nil || s == nil {
+		return nil
+	}
+	switch {
+	case s.Example != nil:
+		return s.Example
+	case s.Default != nil:
+		return s.Default
+	case len(s.Enum) > 0:
+		return s.Enum[0]
+	case len(s.OneOf) > 0:
+		return doc.exampleValue(s.OneOf[0], refs)
+	case len(s.AnyOf) > 0:
+		return doc.exampleValue(s.AnyOf[0], refs)
+	}
+
+	typ := s.Type.name()
+	if typ == "" && (len(s.Properties) > 0 || len(s.AllOf) > 0) {
+		typ = "object"
+	}
+	switch typ {
+	case "object":
+		obj := map[string]interface{}{}
+		props, _ := doc.properties(s)
+		for n, p := range props {
+			if ps, err := doc.resolveSchema(p); err == nil && ps != nil && !ps.WriteOnly {
+				obj[n] = doc.exampleValue(p, refs) // p, not ps, so its $ref counts.
+			}
+		}
+		return obj
+	case "array":
+		n := 1
+		if s.MinItems != nil && *s.MinItems > n {
+			n = *s.MinItems
+		}
+		item := doc.exampleValue(s.Items, refs)
+		if item == nil {
+			return []interface{}{} // Children of a recursive schema.
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i] = item
+		}
+		return items
+	case "integer":
+		if s.Minimum != nil {
+			return int64(*s.Minimum)
+		}
+		return 1
+	case "number":
+		if s.Minimum != nil {
+			return *s.Minimum
+		}
+		return 1.5
+	case "boolean":
+		return true
+	case "string":
+		return exampleString(s)
+	}
+	return nil
+}
+
+func exampleString(s *schema) string {
+	v := "string"
+	switch s.Format {
+	case "date-time":
+		v = "2020-01-01T00:00:00Z"
+	case "date":
+		v = "2020-01-01"
+	case "email":
+		v = "user@example.com"
+	case "uuid":
+		v = "3fa85f64-5717-4562-b3fc-2c963f66afa6"
+	case "uri":
+		v = "https://example.com"
+	case "ipv4":
+		v = "192.0.2.1"
+	case "ipv6":
+		v = "2001:db8::1"
+	}
+	if s.MinLength != nil && len(v) < *s.MinLength {
+		v += strings.Repeat("x", *s.MinLength-len(v))
+	}
+	if s.MaxLength != nil && len(v) > *s.MaxLength {
+		v = v[:*s.MaxLength]
+	}
+	return v
+}
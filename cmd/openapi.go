@@ -0,0 +1,341 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	connection "github.com/jdrivas/conman"
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
+)
+
+/*
+OpenAPI
+
+A connection can point at an OpenAPI 3 document:
+
+connections:
+      petstore:
+            serviceURL: https://petstore.example.com/v1
+            openapi: ./petstore.yaml         # a file, or
+            openapi: /openapi.json           # a path on the service, or
+            openapi: https://example.com/openapi.json
+
+Paths are tried as a file first, so a path on the service has to start with /
+and not be a file that's lying around. The spec's servers are ignored,
+requests go to the connection's serviceURL, so put any base path there.
+
+This is only as much of OpenAPI as we need to build commands and check JSON:
+paths, operations, parameters, request bodies, responses and schemas, with local
+$refs (#/components/...). External $refs aren't followed.
+*/
+
+const openAPIKey = "openapi"
+
+type openAPIDoc struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       openAPIInfo          `json:"info"`
+	Paths      map[string]*pathItem `json:"paths"`
+	Components openAPIComponents    `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+}
+
+type openAPIComponents struct {
+	Schemas       map[string]*schema       `json:"schemas"`
+	Parameters    map[string]*parameter    `json:"parameters"`
+	RequestBodies map[string]*openAPIBody  `json:"requestBodies"`
+	Responses     map[string]*openAPIReply `json:"responses"`
+}
+
+type pathItem struct {
+	Parameters []*parameter `json:"parameters"`
+	Get        *operation   `json:"get"`
+	Put        *operation   `json:"put"`
+	Post       *operation   `json:"post"`
+	Delete     *operation   `json:"delete"`
+	Options    *operation   `json:"options"`
+	Head       *operation   `json:"head"`
+	Patch      *operation   `json:"patch"`
+	Trace      *operation   `json:"trace"`
+}
+
+type operation struct {
+	OperationID string                   `json:"operationId"`
+	Summary     string                   `json:"summary"`
+	Description string                   `json:"description"`
+	Tags        []string                 `json:"tags"`
+	Deprecated  bool                     `json:"deprecated"`
+	Parameters  []*parameter             `json:"parameters"`
+	RequestBody *openAPIBody             `json:"requestBody"`
+	Responses   map[string]*openAPIReply `json:"responses"`
+
+	// Filled in when the doc is loaded.
+	Method string `json:"-"`
+	Path   string `json:"-"`
+}
+
+type parameter struct {
+	Ref         string  `json:"$ref"`
+	Name        string  `json:"name"`
+	In          string  `json:"in"` // path, query, header or cookie
+	Description string  `json:"description"`
+	Required    bool    `json:"required"`
+	Deprecated  bool    `json:"deprecated"`
+	Schema      *schema `json:"schema"`
+}
+
+type openAPIBody struct {
+	Ref         string                `json:"$ref"`
+	Description string                `json:"description"`
+	Required    bool                  `json:"required"`
+	Content     map[string]*mediaType `json:"content"`
+}
+
+type openAPIReply struct {
+	Ref         string                `json:"$ref"`
+	Description string                `json:"description"`
+	Content     map[string]*mediaType `json:"content"`
+}
+
+type mediaType struct {
+	Schema   *schema                  `json:"schema"`
+	Example  interface{}              `json:"example"`
+	Examples map[string]*mediaExample `json:"examples"`
+}
+
+type mediaExample struct {
+	Summary string      `json:"summary"`
+	Value   interface{} `json:"value"`
+}
+
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 schemaType         `json:"type"`
+	Format               string             `json:"format"`
+	Description          string             `json:"description"`
+	Enum                 []interface{}      `json:"enum"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"` // true, false or a schema
+	Items                *schema            `json:"items"`
+	AllOf                []*schema          `json:"allOf"`
+	AnyOf                []*schema          `json:"anyOf"`
+	OneOf                []*schema          `json:"oneOf"`
+	Nullable             bool               `json:"nullable"`
+	ReadOnly             bool               `json:"readOnly"`
+	WriteOnly            bool               `json:"writeOnly"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+	Pattern              string             `json:"pattern"`
+	Example              interface{}        `json:"example"`
+	Default              interface{}        `json:"default"`
+}
+
+// schemaType is a type name, or in 3.1 a list of them.
+type schemaType []string
+
+func (st *schemaType) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*st = schemaType{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return fmt.Errorf("schema type should be a string or a list of strings: %s", b)
+	}
+	*st = many
+	return nil
+}
+
+// is says whether typ is one of the types.
+func (st schemaType) is(typ string) bool {
+	for _, s := range st {
+		if s == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// name is the first type that isn't null, or "" if there isn't one.
+func (st schemaType) name() string {
+	for _, s := range st {
+		if s != "null" {
+			return s
+		}
+	}
+	return ""
+}
+
+// Loading
+//
+
+// The specs we've read this session, by where they came from.
+var openAPIDocs = map[string]*openAPIDoc{}
+
+// connectionOpenAPI returns the spec the connection points at.
+func connectionOpenAPI(conn *connection.Connection) (*openAPIDoc, error) {
+	src := viper.GetString(connectionKey(conn.Name, openAPIKey))
+	if src == "" {
+		return nil, fmt.Errorf("connection %q doesn't have an OpenAPI spec, set %s", conn.Name, connectionKey(conn.Name, openAPIKey))
+	}
+	key := conn.Name + " " + src
+	if doc, ok := openAPIDocs[key]; ok {
+		return doc, nil
+	}
+	b, err := readOpenAPI(conn, src)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseOpenAPI(b)
+	if err != nil {
+		return nil, fmt.Errorf("OpenAPI spec %s: %v", src, err)
+	}
+	openAPIDocs[key] = doc
+	return doc, nil
+}
+
+// readOpenAPI gets the spec from a file, a URL or a path on the service.
+func readOpenAPI(conn *connection.Connection, src string) ([]byte, error) {
+	fn := expandHome(src)
+	if _, err := os.Stat(fn); err == nil {
+		return ioutil.ReadFile(fn)
+	}
+
+	var resp *http.Response
+	var err error
+	switch {
+	case strings.HasPrefix(src, "http://"), strings.HasPrefix(src, "https://"):
+		resp, err = http.Get(src)
+		if err == nil && resp.StatusCode >= 300 {
+			err = fmt.Errorf("%s", resp.Status)
+		}
+	case strings.HasPrefix(src, "/"):
+		_, resp, err = conn.Get(src, nil)
+	default:
+		return nil, fmt.Errorf("can't find the OpenAPI spec file %s", src)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting the OpenAPI spec %s: %v", src, err)
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// parseOpenAPI reads a JSON or YAML spec.
+// YAML goes to JSON first so there's only the one set of tags.
+func parseOpenAPI(b []byte) (*openAPIDoc, error) {
+	if !json.Valid(b) {
+		var y interface{}
+		if err := yaml.Unmarshal(b, &y); err != nil {
+			return nil, err
+		}
+		var err error
+		if b, err = json.Marshal(yamlToJSON(y)); err != nil {
+			return nil, err
+		}
+	}
+	doc := &openAPIDoc{}
+	if err := json.Unmarshal(b, doc); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("only OpenAPI 3 is supported, this is %q", doc.OpenAPI)
+	}
+	for p, pi := range doc.Paths {
+		for m, op := range pi.operations() {
+			op.Method, op.Path = m, p
+			if op.OperationID == "" {
+				op.OperationID = defaultOperationID(m, p)
+			}
+		}
+	}
+	return doc, nil
+}
+
+// operations are the path's operations by method.
+func (pi *pathItem) operations() map[string]*operation {
+	ops := map[string]*operation{}
+	for m, op := range map[string]*operation{
+		http.MethodGet: pi.Get, http.MethodPut: pi.Put, http.MethodPost: pi.Post, http.MethodDelete: pi.Delete,
+		http.MethodOptions: pi.Options, http.MethodHead: pi.Head, http.MethodPatch: pi.Patch, http.MethodTrace: pi.Trace,
+	} {
+		if op != nil {
+			ops[m] = op
+		}
+	}
+	return ops
+}
+
+var nonWordRE = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// defaultOperationID makes an id for operations that don't have one: GET /users/{id} is get-users-id.
+func defaultOperationID(method, path string) string {
+	return strings.ToLower(strings.Trim(nonWordRE.ReplaceAllString(method+"-"+path, "-"), "-"))
+}
+
+// allOperations in operationId order.
+func (doc *openAPIDoc) allOperations() (ops []*operation) {
+	for _, pi := range doc.Paths {
+		for _, op := range pi.operations() {
+			ops = append(ops, op)
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].OperationID < ops[j].OperationID })
+	return ops
+}
+
+// findOperation finds the operation for a request path, which is relative to the serviceURL,
+// and the values of its path parameters. The template with the fewest parameters wins,
+// so /users/me beats /users/{id}, then the longest.
+func (doc *openAPIDoc) findOperation(method, path string) (op *operation, params map[string]string) {
+	best, bestPath := -1, ""
+	for p, pi := range doc.Paths {
+		o := pi.operations()[strings.ToUpper(method)]
+		if o == nil {
+			continue
+		}
+		re, names := pathPattern(p)
+		m := re.FindStringSubmatch(path)
+		if m == nil || (best >= 0 && (len(names) > best || (len(names) == best && len(p) <= len(bestPath)))) {
+			continue
+		}
+		op, best, bestPath, params = o, len(names), p, map[string]string{}
+		for i, n := range names {
+			params[n] = m[i+1]
+		}
+	}
+	return op, params
+}
+
+var templateParamRE = regexp.MustCompile(`\{([^}/]+)\}`)
+
+// pathPattern makes a regexp out of a path template, /users/{id} matches /users/3 (and /users/3/).
+func pathPattern(p string) (*regexp.Regexp, []string) {
+	names := []string{}
+	pat := ""
+	last := 0
+	for _, m := range templateParamRE.FindAllStringSubmatchIndex(p, -1) {
+		pat += regexp.QuoteMeta(p[last:m[0]]) + `([^/]+)`
+		names = append(names, p[m[2]:m[3]])
+		last = m[1]
+	}
+	pat += regexp.QuoteMeta(strings.TrimSuffix(p[last:], "/"))
+	return regexp.MustCompile("^" + pat + "/?$"), names
+}
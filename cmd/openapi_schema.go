@@ -0,0 +1,412 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// $refs
+//
+
+// Far enough to get through any sane chain of refs, and out of an insane loop.
+const maxRefDepth = 32
+
+// refName is the component name in a local ref, #/components/<kind>/<name>.
+func refName(ref, kind string) (string, error) {
+	prefix := "#/components/" + kind + "/"
+	if !strings.HasPrefix(ref, prefix) {
+		return "", fmt.Errorf("can't follow $ref %q, only %s... refs are supported", ref, prefix)
+	}
+	n := ref[len(prefix):]
+	return strings.Replace(strings.Replace(n, "~1", "/", -1), "~0", "~", -1), nil
+}
+
+func (doc *openAPIDoc) resolveSchema(s *schema) (*schema, error) {
+	for i := 0; s != nil && s.Ref != ""; i++ {
+		if i == maxRefDepth {
+			return nil, fmt.Errorf("$ref loop at %q", s.Ref)
+		}
+		n, err := refName(s.Ref, "schemas")
+		if err != nil {
+			return nil, err
+		}
+		ref := s.Ref
+		if s = doc.Components.Schemas[n]; s == nil {
+			return nil, fmt.Errorf("no schema for $ref %q", ref)
+		}
+	}
+	return s, nil
+}
+
+func (doc *openAPIDoc) resolveParameter(p *parameter) (*parameter, error) {
+	for i := 0; p != nil && p.Ref != ""; i++ {
+		if i == maxRefDepth {
+			return nil, fmt.Errorf("$ref loop at %q", p.Ref)
+		}
+		n, err := refName(p.Ref, "parameters")
+		if err != nil {
+			return nil, err
+		}
+		ref := p.Ref
+		if p = doc.Components.Parameters[n]; p == nil {
+			return nil, fmt.Errorf("no parameter for $ref %q", ref)
+		}
+	}
+	return p, nil
+}
+
+func (doc *openAPIDoc) resolveBody(b *openAPIBody) (*openAPIBody, error) {
+	for i := 0; b != nil && b.Ref != ""; i++ {
+		if i == maxRefDepth {
+			return nil, fmt.Errorf("$ref loop at %q", b.Ref)
+		}
+		n, err := refName(b.Ref, "requestBodies")
+		if err != nil {
+			return nil, err
+		}
+		ref := b.Ref
+		if b = doc.Components.RequestBodies[n]; b == nil {
+			return nil, fmt.Errorf("no request body for $ref %q", ref)
+		}
+	}
+	return b, nil
+}
+
+func (doc *openAPIDoc) resolveReply(r *openAPIReply) (*openAPIReply, error) {
+	for i := 0; r != nil && r.Ref != ""; i++ {
+		if i == maxRefDepth {
+			return nil, fmt.Errorf("$ref loop at %q", r.Ref)
+		}
+		n, err := refName(r.Ref, "responses")
+		if err != nil {
+			return nil, err
+		}
+		ref := r.Ref
+		if r = doc.Components.Responses[n]; r == nil {
+			return nil, fmt.Errorf("no response for $ref %q", ref)
+		}
+	}
+	return r, nil
+}
+
+// parameters are the operation's parameters, including the ones from the path
+// that the operation doesn't override.
+func (doc *openAPIDoc) parameters(op *operation) (params []*parameter, err error) {
+	seen := map[string]bool{}
+	add := func(ps []*parameter) error {
+		for _, p := range ps {
+			p, err := doc.resolveParameter(p)
+			if err != nil {
+				return err
+			}
+			if k := p.In + " " + p.Name; !seen[k] {
+				seen[k] = true
+				params = append(params, p)
+			}
+		}
+		return nil
+	}
+	if err = add(op.Parameters); err == nil {
+		err = add(doc.Paths[op.Path].Parameters)
+	}
+	return params, err
+}
+
+// jsonSchema finds the JSON schema in a content map.
+func jsonSchema(content map[string]*mediaType) *schema {
+	for ct, mt := range content {
+		if isJSONContent(ct) {
+			return mt.Schema
+		}
+	}
+	return nil
+}
+
+func isJSONContent(ct string) bool {
+	ct = strings.ToLower(strings.TrimSpace(strings.Split(ct, ";")[0]))
+	return ct == "application/json" || strings.HasSuffix(ct, "+json") || ct == "*/*"
+}
+
+// properties are the object's properties, including the ones from allOf.
+func (doc *openAPIDoc) properties(s *schema) (props map[string]*schema, required []string) {
+	props = map[string]*schema{}
+	var walk func(s *schema, depth int)
+	walk = func(s *schema, depth int) {
+		s, err := doc.resolveSchema(s)
+		if err != nil || s == nil || depth > maxRefDepth {
+			return
+		}
+		for n, p := range s.Properties {
+			props[n] = p
+		}
+		required = append(required, s.Required...)
+		for _, sub := range s.AllOf {
+			walk(sub, depth+1)
+		}
+	}
+	walk(s, 0)
+	return props, required
+}
+
+// Validation
+//
+
+// validate checks the decoded JSON value v (numbers are json.Numbers) against the schema,
+// and returns a line for each problem, starting with a JSON pointer to where it is (#/users/0/email).
+// Requests don't need to have readOnly properties, and responses don't need writeOnly ones.
+func (doc *openAPIDoc) validate(s *schema, v interface{}, request bool) []string {
+	return doc.validateAt(s, v, "#", request, 0)
+}
+
+func (doc *openAPIDoc) validateAt(s *schema, v interface{}, at string, request bool, depth int) (problems []string) {
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, at+": "+fmt.Sprintf(format, args...))
+	}
+	s, err := doc.resolveSchema(s)
+	if err != nil {
+		problem("%v", err)
+		return problems
+	}
+	if s == nil {
+		return nil
+	}
+	if depth > maxRefDepth {
+		problem("schema is nested too deep to check")
+		return problems
+	}
+
+	for _, sub := range s.AllOf {
+		problems = append(problems, doc.validateAt(sub, v, at, request, depth+1)...)
+	}
+	if len(s.AnyOf) > 0 {
+		ok := false
+		for _, sub := range s.AnyOf {
+			if len(doc.validateAt(sub, v, at, request, depth+1)) == 0 {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			problem("doesn't match any of the anyOf schemas")
+		}
+	}
+	if len(s.OneOf) > 0 {
+		n := 0
+		for _, sub := range s.OneOf {
+			if len(doc.validateAt(sub, v, at, request, depth+1)) == 0 {
+				n++
+			}
+		}
+		if n != 1 {
+			problem("matches %d of the oneOf schemas, it should match exactly one", n)
+		}
+	}
+
+	if v == nil {
+		if len(s.Type) > 0 && !s.Nullable && !s.Type.is("null") {
+			problem("is null, should be %s", strings.Join(s.Type, " or "))
+		}
+		return problems
+	}
+
+	kind := jsonKind(v)
+	if len(s.Type) > 0 && !s.Type.is(kind) && !(kind == "integer" && s.Type.is("number")) {
+		problem("is %s, should be %s", kind, strings.Join(s.Type, " or "))
+		return problems
+	}
+
+	if len(s.Enum) > 0 && !inEnum(v, s.Enum) {
+		problem("%s isn't one of %s", jsonValueString(v), enumString(s.Enum))
+	}
+
+	switch val := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(val)
+		if s.MinLength != nil && n < *s.MinLength {
+			problem("is %d characters, should be at least %d", n, *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			problem("is %d characters, should be at most %d", n, *s.MaxLength)
+		}
+		if s.Pattern != "" {
+			if re, err := regexp.Compile(s.Pattern); err == nil && !re.MatchString(val) {
+				problem("%q doesn't match %s", val, s.Pattern)
+			}
+		}
+		if !validFormat(s.Format, val) {
+			problem("%q isn't a valid %s", val, s.Format)
+		}
+
+	case json.Number:
+		f, _ := val.Float64()
+		if s.Minimum != nil && f < *s.Minimum {
+			problem("%s is less than the minimum %g", val, *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			problem("%s is more than the maximum %g", val, *s.Maximum)
+		}
+
+	case []interface{}:
+		if s.MinItems != nil && len(val) < *s.MinItems {
+			problem("has %d items, should have at least %d", len(val), *s.MinItems)
+		}
+		if s.MaxItems != nil && len(val) > *s.MaxItems {
+			problem("has %d items, should have at most %d", len(val), *s.MaxItems)
+		}
+		for i, item := range val {
+			problems = append(problems, doc.validateAt(s.Items, item, fmt.Sprintf("%s/%d", at, i), request, depth+1)...)
+		}
+
+	case map[string]interface{}:
+		for _, n := range s.Required {
+			if _, ok := val[n]; ok {
+				continue
+			}
+			if p, _ := doc.resolveSchema(s.Properties[n]); p != nil && ((request && p.ReadOnly) || (!request && p.WriteOnly)) {
+				continue
+			}
+			problem("%s is required", n)
+		}
+		// The allOf schemas check their own properties, so extras are only extra
+		// if nobody in the allOf has them.
+		all, _ := doc.properties(s)
+		extra, _ := doc.resolveAdditional(s)
+		for _, k := range sortedKeys(val) {
+			kat := at + "/" + pointerEscape(k)
+			if p, ok := s.Properties[k]; ok {
+				problems = append(problems, doc.validateAt(p, val[k], kat, request, depth+1)...)
+				continue
+			}
+			if _, ok := all[k]; ok {
+				continue
+			}
+			switch {
+			case extra == nil:
+			case extra == noAdditional:
+				problems = append(problems, kat+": isn't an allowed property")
+			default:
+				problems = append(problems, doc.validateAt(extra, val[k], kat, request, depth+1)...)
+			}
+		}
+	}
+	return problems
+}
+
+func pointerEscape(k string) string {
+	return strings.Replace(strings.Replace(k, "~", "~0", -1), "/", "~1", -1)
+}
+
+// noAdditional stands in for additionalProperties: false.
+var noAdditional = &schema{}
+
+// resolveAdditional returns the schema for additional properties, noAdditional if there can't be any,
+// or nil if anything goes.
+func (doc *openAPIDoc) resolveAdditional(s *schema) (*schema, error) {
+	raw := strings.TrimSpace(string(s.AdditionalProperties))
+	switch raw {
+	case "", "true", "null":
+		return nil, nil
+	case "false":
+		return noAdditional, nil
+	}
+	as := &schema{}
+	if err := json.Unmarshal(s.AdditionalProperties, as); err != nil {
+		return nil, err
+	}
+	return as, nil
+}
+
+// jsonKind is the schema type of a decoded JSON value.
+func jsonKind(v interface{}) string {
+	switch n := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := n.Int64(); err == nil {
+			return "integer"
+		}
+		if f, err := n.Float64(); err == nil && f == float64(int64(f)) && !strings.ContainsAny(string(n), ".eE") {
+			return "integer"
+		}
+		return "number"
+	case float64:
+		if n == float64(int64(n)) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// inEnum compares as JSON, so the number 3 from the spec is the 3 from the wire.
+func inEnum(v interface{}, enum []interface{}) bool {
+	b, _ := json.Marshal(v)
+	for _, e := range enum {
+		if eb, _ := json.Marshal(e); string(eb) == string(b) {
+			return true
+		}
+	}
+	return false
+}
+
+func enumString(enum []interface{}) string {
+	vs := make([]string, len(enum))
+	for i, e := range enum {
+		vs[i] = jsonValueString(e)
+	}
+	return strings.Join(vs, ", ")
+}
+
+var uuidRE = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// validFormat checks the formats we know about, anything else is fine.
+func validFormat(format, s string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, s)
+		return err == nil
+	case "date":
+		_, err := time.Parse("2006-01-02", s)
+		return err == nil
+	case "email":
+		i := strings.Index(s, "@")
+		return i > 0 && i < len(s)-1
+	case "uuid":
+		return uuidRE.MatchString(s)
+	case "ipv4":
+		ip := net.ParseIP(s)
+		return ip != nil && ip.To4() != nil
+	case "ipv6":
+		ip := net.ParseIP(s)
+		return ip != nil && strings.Contains(s, ":")
+	case "uri":
+		u, err := url.Parse(s)
+		return err == nil && u.Scheme != ""
+	}
+	return true
+}
+
+// sortedProperties are the property names in order.
+func sortedProperties(props map[string]*schema) []string {
+	names := make([]string, 0, len(props))
+	for n := range props {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	t "github.com/jdrivas/termtext"
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
+)
+
+/*
+Output formats
+
+--output (-o) picks how a command prints its results:
+
+	table                the usual terminal display (the default)
+	json                 indented JSON
+	yaml                 YAML
+	csv                  a header and a row for each item
+	ndjson               one line of JSON for each item
+	raw                  strings and response bodies as they are, no decoration
+	template=<template>  a Go template, executed on the JSON form of the result
+
+--json is still around and is the same as -o json, unless -o says otherwise.
+
+Commands hand printOutput the value to print and a function that prints the table.
+The non-table formats all work from the value's JSON form, so the json tags
+decide the names for yaml, csv and templates too.
+*/
+
+const outputKey = "output" // string
+
+const (
+	tableOutput    = "table"
+	jsonOutput     = "json"
+	yamlOutput     = "yaml"
+	csvOutput      = "csv"
+	ndjsonOutput   = "ndjson"
+	rawOutput      = "raw"
+	templateOutput = "template"
+)
+
+var outputFormats = []string{tableOutput, jsonOutput, yamlOutput, csvOutput, ndjsonOutput, rawOutput, templateOutput + "=<template>"}
+
+// outputFormat returns the format from --output (or --json), and the template if there is one.
+func outputFormat() (format, tmpl string) {
+	o := strings.TrimSpace(viper.GetString(outputKey))
+	switch {
+	case o == "":
+		if viper.GetBool(t.JSONDisplayKey) {
+			return jsonOutput, ""
+		}
+		return tableOutput, ""
+	case strings.HasPrefix(o, templateOutput+"="):
+		return templateOutput, o[len(templateOutput)+1:]
+	}
+	return strings.ToLower(o), ""
+}
+
+// tableOutputFormat is true for the usual terminal display.
+func tableOutputFormat() bool {
+	f, _ := outputFormat()
+	return f == tableOutput
+}
+
+// Objects can print a format their own way.
+type csvPrinter interface {
+	printCSV()
+}
+
+// printOutput prints v in the current output format, using table for the table format.
+func printOutput(v interface{}, table func()) {
+	format, tmpl := outputFormat()
+	if format == tableOutput {
+		table()
+		return
+	}
+	if format == csvOutput {
+		if c, ok := v.(csvPrinter); ok {
+			c.printCSV()
+			return
+		}
+	}
+	if err := writeOutput(format, tmpl, v); err != nil {
+		fmt.Printf("%s\n", t.Error(err))
+	}
+}
+
+func writeOutput(format, tmpl string, v interface{}) error {
+	if format == jsonOutput {
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err == nil {
+			fmt.Printf("%s\n", b)
+		}
+		return err
+	}
+
+	doc, err := jsonForm(v)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case yamlOutput:
+		b, err := yaml.Marshal(yamlNumbers(doc))
+		if err == nil {
+			fmt.Printf("%s", b)
+		}
+		return err
+	case ndjsonOutput:
+		for _, item := range outputItems(doc) {
+			b, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s\n", b)
+		}
+	case csvOutput:
+		return writeCSV(outputItems(doc))
+	case rawOutput:
+		for _, item := range outputItems(doc) {
+			fmt.Printf("%s\n", jsonValueString(item))
+		}
+	case templateOutput:
+		tp, err := template.New("output").Funcs(template.FuncMap{
+			"json": func(v interface{}) string { return jsonValueString(v) },
+		}).Parse(tmpl)
+		if err != nil {
+			return err
+		}
+		var b bytes.Buffer
+		if err = tp.Execute(&b, doc); err != nil {
+			return err
+		}
+		if b.Len() > 0 && !bytes.HasSuffix(b.Bytes(), []byte("\n")) {
+			b.WriteByte('\n')
+		}
+		b.WriteTo(os.Stdout)
+	default:
+		return fmt.Errorf("unknown output format %q, use one of: %s", format, strings.Join(outputFormats, ", "))
+	}
+	return nil
+}
+
+// jsonForm round trips v through JSON to get plain maps, slices and values.
+func jsonForm(v interface{}) (doc interface{}, err error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	err = d.Decode(&doc)
+	return doc, err
+}
+
+// yamlNumbers swaps json.Numbers for numbers, yaml would quote them as strings.
+func yamlNumbers(doc interface{}) interface{} {
+	switch v := doc.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = yamlNumbers(v[k])
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = yamlNumbers(v[i])
+		}
+	}
+	return doc
+}
+
+// outputItems are the rows for the line oriented formats: an array is one item per element.
+func outputItems(doc interface{}) []interface{} {
+	switch d := doc.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return d
+	}
+	return []interface{}{doc}
+}
+
+// writeCSV writes objects with a column for each key, or anything else in a value column.
+// Nested values are written as JSON.
+func writeCSV(items []interface{}) error {
+	columns := []string{}
+	seen := map[string]bool{}
+	for _, item := range items {
+		if m, ok := item.(map[string]interface{}); ok {
+			for k := range m {
+				if !seen[k] {
+					seen[k] = true
+					columns = append(columns, k)
+				}
+			}
+		}
+	}
+	sort.Strings(columns)
+	scalars := len(columns) == 0
+
+	w := csv.NewWriter(os.Stdout)
+	if scalars {
+		w.Write([]string{"value"})
+	} else {
+		w.Write(columns)
+	}
+	for _, item := range items {
+		if scalars {
+			w.Write([]string{jsonValueString(item)})
+			continue
+		}
+		m, _ := item.(map[string]interface{})
+		row := make([]string, len(columns))
+		for i, c := range columns {
+			if v, ok := m[c]; ok {
+				row[i] = jsonValueString(v)
+			}
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return w.Error()
+}
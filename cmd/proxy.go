@@ -0,0 +1,299 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	connection "github.com/jdrivas/conman"
+	t "github.com/jdrivas/termtext"
+	config "github.com/jdrivas/vconfig"
+	"github.com/spf13/cobra"
+)
+
+/*
+Recording Proxy
+
+serve proxy sits in front of a connection's service and forwards everything to it,
+printing each exchange as it goes by:
+
+	gafw serve proxy -c prod --listen :9000 --save traffic.har
+
+Point another client (a browser, a front end, someone else's script) at the proxy
+to see what it sends and what comes back. Requests go out through our transport,
+so the connection's TLS settings, auth, headers and stats apply, as does --record.
+
+--save keeps the exchanges, as a HAR if the file name ends in .har and as a cassette
+(see cassette.go) otherwise, so the traffic can be replayed with --replay. A cassette
+gets added to, a HAR gets written fresh. Either way the file is kept up to date while
+the proxy runs, and credentials are redacted.
+
+-v prints the headers too. The proxy runs until it's interrupted, in interactive mode
+too. Unlike serve mock it can't run in the background: each request goes through the
+transport, which reads viper, and viper can't be read while the prompt sets things.
+*/
+
+var proxyCmd *cobra.Command
+
+func buildProxy(mode runMode) {
+	proxyCmd = &cobra.Command{
+		Use:   "proxy [flags]",
+		Short: "Proxy to the connection's service, showing and saving the traffic.",
+		Long: `Run a local reverse proxy to the current connection's service URL. Every
+exchange is printed as it happens and can be saved to a cassette or a HAR file.`,
+		Example: fmt.Sprintf("  %s serve proxy --connection prod --listen :9000 --save prod.har", config.AppName),
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			serveProxy()
+		},
+	}
+	serveCmd.AddCommand(proxyCmd)
+	initProxyFlags()
+}
+
+// Proxy flags
+//
+
+var (
+	proxyListenFlag string
+	proxySaveFlag   string
+)
+
+const (
+	proxyListenFlagKey = "listen"
+	proxySaveFlagKey   = "save"
+)
+
+func initProxyFlags() {
+	proxyCmd.Flags().StringVar(&proxyListenFlag, proxyListenFlagKey, "127.0.0.1:9000", "Address to listen on, host:port or :port.")
+	proxyCmd.Flags().StringVar(&proxySaveFlag, proxySaveFlagKey, "", "Save the exchanges to a file, a HAR if it ends in .har, a cassette otherwise.")
+}
+
+// How often the save file is brought up to date.
+const proxySaveInterval = 2 * time.Second
+
+func serveProxy() {
+	conn, err := connection.GetCurrentConnection()
+	if err != nil {
+		fmt.Printf("%s\n", t.Error(err))
+		return
+	}
+	target, err := url.Parse(conn.ServiceURL)
+	if err == nil && (target.Scheme == "" || target.Host == "") {
+		err = fmt.Errorf("connection %q has service URL %q, it needs a scheme and a host", conn.Name, conn.ServiceURL)
+	}
+	if err != nil {
+		fmt.Printf("%s\n", t.Error(err))
+		return
+	}
+
+	var saver *proxySaver
+	if proxySaveFlag != "" {
+		if saver, err = newProxySaver(proxySaveFlag); err != nil {
+			fmt.Printf("%s\n", t.Error(err))
+			return
+		}
+	}
+
+	l, err := net.Listen("tcp", proxyListenFlag)
+	if err != nil {
+		fmt.Printf("%s\n", t.Error(err))
+		return
+	}
+
+	p := &proxy{conn: conn, target: target, saver: saver, verbose: config.Verbose()}
+	srv := &http.Server{Handler: &httputil.ReverseProxy{Director: p.direct, Transport: p, ErrorHandler: p.failed}}
+	fmt.Printf("%s\n", t.Title("Proxying http://%s to %s, connection %s.", l.Addr(), conn.ServiceURL, conn.Name))
+	if saver != nil {
+		fmt.Printf("%s\n", t.Info("Saving the exchanges to %s.", saver.c.path))
+		go saver.keepSaving()
+	}
+
+	fmt.Printf("%s\n", t.Info("Interrupt to stop."))
+	stopOnInterrupt(srv)
+	if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+		fmt.Printf("%s\n", t.Error(err))
+	}
+	if saver != nil {
+		saver.finish()
+	}
+}
+
+type proxy struct {
+	conn    *connection.Connection
+	target  *url.URL
+	saver   *proxySaver
+	verbose bool
+}
+
+// direct points the request at the service.
+func (p *proxy) direct(req *http.Request) {
+	prefix := strings.TrimSuffix(p.target.Path, "/")
+	req.URL.Scheme = p.target.Scheme
+	req.URL.Host = p.target.Host
+	req.URL.Path = prefix + req.URL.Path
+	if req.URL.RawPath != "" {
+		req.URL.RawPath = prefix + req.URL.RawPath
+	}
+	req.Host = "" // The service's, not ours.
+	for k, v := range p.conn.Headers {
+		if req.Header.Get(k) == "" {
+			req.Header.Set(k, v)
+		}
+	}
+	if _, ok := req.Header["User-Agent"]; !ok {
+		req.Header.Set("User-Agent", "") // Don't add Go's.
+	}
+}
+
+// RoundTrip sends the request through our transport and shows and saves the exchange.
+func (p *proxy) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := requestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	rt := http.DefaultClient.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	start := time.Now()
+	resp, err := rt.RoundTrip(verbatim(req)) // A client's request, not ours to fill in.
+	if err != nil {
+		return nil, err
+	}
+	body, err := responseBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	ex := responseExchange(resp)
+	in := newInteraction(ex, req, reqBody, resp, body)
+	if ex == nil {
+		in.Millis = ms(time.Since(start))
+	}
+	p.show(in, len(body))
+	if p.saver != nil {
+		p.saver.c.add(in)
+	}
+	return resp, nil
+}
+
+// failed answers a request the service didn't.
+func (p *proxy) failed(w http.ResponseWriter, r *http.Request, err error) {
+	fmt.Printf("%s %s\n", t.Title("%s %s %s", time.Now().Format("15:04:05"), r.Method, r.URL.RequestURI()), t.Fail("%v", err))
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// show prints the exchange, with the headers in verbose mode.
+func (p *proxy) show(in *interaction, size int) {
+	path := in.Request.URL
+	if u, err := url.Parse(in.Request.URL); err == nil {
+		u.Path = servicePath(p.conn, u)
+		path = u.RequestURI()
+	}
+	status := fmt.Sprintf("%d %s %s", in.Response.Status, durationMs(time.Duration(in.Millis*float64(time.Millisecond))), byteSize(size))
+	switch {
+	case in.Response.Status >= 500:
+		status = t.Fail("%s", status)
+	case in.Response.Status >= 400:
+		status = t.Warn("%s", status)
+	default:
+		status = t.Success("%s", status)
+	}
+	fmt.Printf("%s %s\n", t.Title("%s %s %s", in.Recorded.Local().Format("15:04:05"), in.Request.Method, path), status)
+	if p.verbose {
+		showProxyHeaders(">", in.Request.Headers)
+		showProxyHeaders("<", in.Response.Headers)
+	}
+}
+
+func showProxyHeaders(dir string, h http.Header) {
+	keys := []string{}
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("  %s %s %s\n", t.Text("%s", dir), t.Info("%s:", k), t.Text("%s", strings.Join(h[k], ", ")))
+	}
+}
+
+func byteSize(n int) string {
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return fmt.Sprintf("%.1fKB", float64(n)/1024)
+}
+
+// Saving
+//
+
+// proxySaver keeps the exchanges in a cassette and writes them out as one, or as a HAR.
+type proxySaver struct {
+	c    *cassette
+	har  bool
+	done chan struct{}
+}
+
+func newProxySaver(path string) (*proxySaver, error) {
+	path = expandHome(path)
+	if strings.EqualFold(filepath.Ext(path), ".har") {
+		return &proxySaver{c: &cassette{Version: cassetteVersion, path: path, plays: map[string]int{}}, har: true, done: make(chan struct{})}, nil
+	}
+	c, err := loadCassette(path, false)
+	if err != nil {
+		return nil, err
+	}
+	return &proxySaver{c: c, done: make(chan struct{})}, nil
+}
+
+func (s *proxySaver) save() error {
+	if !s.har {
+		return s.c.save()
+	}
+	s.c.mu.Lock()
+	defer s.c.mu.Unlock()
+	if !s.c.dirty {
+		return nil
+	}
+	if err := writeHAR(s.c.path, s.c.Interactions); err != nil {
+		return err
+	}
+	s.c.dirty = false
+	return nil
+}
+
+// keepSaving saves regularly, so what's been captured is there even if we don't stop cleanly.
+// It stops when finish is called.
+func (s *proxySaver) keepSaving() {
+	ticker := time.NewTicker(proxySaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.save(); err != nil {
+				fmt.Printf("%s\n", t.Error(err))
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *proxySaver) finish() {
+	close(s.done)
+	if err := s.save(); err != nil {
+		fmt.Printf("%s\n", t.Error(err))
+		return
+	}
+	s.c.mu.Lock()
+	n := len(s.c.Interactions)
+	s.c.mu.Unlock()
+	fmt.Printf("%s\n", t.Success("%d exchanges in %s.", n, s.c.path))
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	jmespath "github.com/jmespath/go-jmespath"
+)
+
+/*
+Response queries
+
+--query takes a JMESPath expression (https://jmespath.org) and applies it to the
+JSON response body before it's displayed. The result replaces the body, so it
+goes through the usual display: colored in the table format, or in whatever
+-o asks for.
+
+--raw prints strings without quotes and arrays one element to a line,
+for piping into the shell:
+
+	gafw http get /users --query '[].id' --raw | xargs -I{} gafw http delete /users/{}
+*/
+
+// applyQuery replaces the response body with the result of the query.
+// Empty bodies are left alone, there's nothing to query.
+func applyQuery(resp *http.Response, query string) error {
+	jq, err := jmespath.Compile(query)
+	if err != nil {
+		return fmt.Errorf("bad query %q: %v", query, err)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return nil
+	}
+
+	var doc interface{}
+	if err = json.Unmarshal(body, &doc); err != nil {
+		return fmt.Errorf("can't query a response that isn't JSON: %v", err)
+	}
+	result, err := jq.Search(doc)
+	if err != nil {
+		return fmt.Errorf("query %q: %v", query, err)
+	}
+	if body, err = json.Marshal(result); err != nil {
+		return err
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.Header.Set("Content-Type", "application/json")
+	return nil
+}
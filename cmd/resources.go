@@ -0,0 +1,332 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	connection "github.com/jdrivas/conman"
+	t "github.com/jdrivas/termtext"
+	config "github.com/jdrivas/vconfig"
+	"github.com/juju/ansiterm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+/*
+REST Resources
+
+Resources declared in the config file get their own commands:
+
+resources:
+      users:
+            singular: user            # default is the name without the trailing s
+            aliases: [u]              # for the singular commands, the name's own aliases go in listAliases
+            listAliases: [us]
+            path: /users              # the collection
+            id: id                    # the field that identifies one, default id
+            items: data               # where the array is in the list response, default the whole body
+            columns: [id, name, email]            # list columns, default all of them
+            fields: [id, name, email, address.city] # describe fields, default all of them
+            updateMethod: PUT         # default PATCH
+
+gives:
+      list users
+      describe user <id>
+      create user [<json-string> ... | @<file>]
+      update user <id> [<json-string> ... | @<file>]
+      delete user <id> ...
+
+all sent over the current connection.
+
+Cobra has to know about the commands before it parses the command line, and
+that's before the config file is normally read. So we take a quick look at the
+config file for resources when the command tree is built, using the --configfile
+from the command line if there is one.
+*/
+
+const (
+	resourcesKey      = "resources"
+	defaultResourceID = "id"
+	defaultUpdateVerb = http.MethodPatch
+)
+
+type resource struct {
+	Name         string
+	Singular     string   `mapstructure:"singular"`
+	Aliases      []string `mapstructure:"aliases"`
+	ListAliases  []string `mapstructure:"listAliases"`
+	Path         string   `mapstructure:"path"`
+	ID           string   `mapstructure:"id"`
+	Items        string   `mapstructure:"items"`
+	Columns      []string `mapstructure:"columns"`
+	Fields       []string `mapstructure:"fields"`
+	UpdateMethod string   `mapstructure:"updateMethod"`
+}
+
+var createCmd, updateCmd *cobra.Command
+
+func buildResources(mode runMode) {
+	resources := readResources()
+	if len(resources) == 0 {
+		return
+	}
+
+	createCmd = &cobra.Command{
+		Use:   "create",
+		Short: "Create an object",
+		Long:  "Create an object on the service.",
+	}
+	rootCmd.AddCommand(createCmd)
+
+	updateCmd = &cobra.Command{
+		Use:   "update",
+		Short: "Update an object",
+		Long:  "Change an object on the service.",
+	}
+	rootCmd.AddCommand(updateCmd)
+
+	for _, r := range resources {
+		if c, _, err := listCmd.Find([]string{r.Name}); err == nil && c != listCmd {
+			fmt.Printf("%s\n", t.Warn("Skipping resource %q: there's already a list %s command.", r.Name, r.Name))
+			continue
+		}
+		r.buildCommands()
+	}
+}
+
+// readResources reads the resource declarations from the config file.
+func readResources() (resources []*resource) {
+	v := viper.New()
+	if fn := configFileArg(os.Args[1:]); fn != "" {
+		v.SetConfigFile(fn)
+	} else {
+		root := config.ConfigFileRoot
+		if root == "" {
+			root = config.AppName
+		}
+		v.SetConfigName(root)
+		v.AddConfigPath(".")
+		if home, err := os.UserHomeDir(); err == nil {
+			v.AddConfigPath(home)
+		}
+	}
+	if err := v.ReadInConfig(); err != nil {
+		return nil // We'll hear about it when the config is read for real.
+	}
+
+	names := []string{}
+	for n := range v.GetStringMap(resourcesKey) {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		r := &resource{Name: n}
+		if err := v.UnmarshalKey(resourcesKey+"."+n, r); err != nil || r.Path == "" {
+			fmt.Printf("%s\n", t.Warn("Skipping resource %q: it needs a path.", n))
+			continue
+		}
+		r.setDefaults()
+		resources = append(resources, r)
+	}
+	return resources
+}
+
+// configFileArg finds --configfile on the command line, ignoring everything else.
+func configFileArg(args []string) string {
+	fs := pflag.NewFlagSet("configfile", pflag.ContinueOnError)
+	fs.ParseErrorsWhitelist.UnknownFlags = true
+	fs.SetOutput(ioutil.Discard)
+	fn := fs.String(configFlagKey, "", "")
+	fs.Parse(args)
+	return *fn
+}
+
+func (r *resource) setDefaults() {
+	if r.Singular == "" {
+		r.Singular = strings.TrimSuffix(r.Name, "s")
+	}
+	if r.ID == "" {
+		r.ID = defaultResourceID
+	}
+	if r.UpdateMethod == "" {
+		r.UpdateMethod = defaultUpdateVerb
+	}
+	r.UpdateMethod = strings.ToUpper(r.UpdateMethod)
+	r.Path = strings.TrimSuffix(r.Path, "/")
+}
+
+func (r *resource) buildCommands() {
+	listCmd.AddCommand(&cobra.Command{
+		Use:     r.Name,
+		Aliases: r.ListAliases,
+		Short:   fmt.Sprintf("List %s.", r.Name),
+		Long:    fmt.Sprintf("Display the %s on the current connection (GET %s).", r.Name, r.Path),
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			r.list()
+		},
+	})
+
+	describeCmd.AddCommand(&cobra.Command{
+		Use:     fmt.Sprintf("%s <%s>", r.Singular, r.ID),
+		Aliases: r.Aliases,
+		Short:   fmt.Sprintf("Details about a %s.", r.Singular),
+		Long:    fmt.Sprintf("Display a %s from the current connection (GET %s/<%s>).", r.Singular, r.Path, r.ID),
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			r.send(http.MethodGet, r.itemPath(args[0]), nil)
+		},
+	})
+
+	createCmd.AddCommand(&cobra.Command{
+		Use:     fmt.Sprintf("%s [<json-string> .... | @<file>]", r.Singular),
+		Aliases: r.Aliases,
+		Short:   fmt.Sprintf("Create a %s.", r.Singular),
+		Long:    fmt.Sprintf("Create a %s with the JSON body (POST %s).", r.Singular, r.Path),
+		Run: func(cmd *cobra.Command, args []string) {
+			if body, err := httpBody(args); err == nil {
+				r.send(http.MethodPost, r.Path, body)
+			} else {
+				fmt.Printf("%s\n", t.Error(err))
+			}
+		},
+	})
+
+	updateCmd.AddCommand(&cobra.Command{
+		Use:     fmt.Sprintf("%s <%s> [<json-string> .... | @<file>]", r.Singular, r.ID),
+		Aliases: r.Aliases,
+		Short:   fmt.Sprintf("Update a %s.", r.Singular),
+		Long:    fmt.Sprintf("Change a %s with the JSON body (%s %s/<%s>).", r.Singular, r.UpdateMethod, r.Path, r.ID),
+		Args:    cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if body, err := httpBody(args[1:]); err == nil {
+				r.send(r.UpdateMethod, r.itemPath(args[0]), body)
+			} else {
+				fmt.Printf("%s\n", t.Error(err))
+			}
+		},
+	})
+
+	deleteCmd.AddCommand(&cobra.Command{
+		Use:     fmt.Sprintf("%s <%s> ...", r.Singular, r.ID),
+		Aliases: r.Aliases,
+		Short:   fmt.Sprintf("Delete %s.", r.Name),
+		Long:    fmt.Sprintf("Delete each of the %s (DELETE %s/<%s>).", r.Name, r.Path, r.ID),
+		Args:    cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			for _, id := range args {
+				r.delete(id)
+			}
+		},
+	})
+}
+
+func (r *resource) itemPath(id string) string {
+	return r.Path + "/" + url.PathEscape(id)
+}
+
+// Sending
+//
+
+// request sends and returns the decoded JSON response.
+func (r *resource) request(method, path string, body interface{}) (doc interface{}, resp *http.Response, err error) {
+	conn, err := connection.GetCurrentConnection()
+	if err != nil {
+		return nil, nil, err
+	}
+	_, resp, err = conn.Send(method, path, body, nil)
+	if err != nil || resp == nil {
+		return nil, resp, err
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	if err == nil && len(bytes.TrimSpace(b)) > 0 {
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		err = d.Decode(&doc)
+	}
+	return doc, resp, err
+}
+
+func (r *resource) list() {
+	doc, resp, err := r.request(http.MethodGet, r.Path, nil)
+	if err == nil && r.Items != "" {
+		doc, err = lookupPath(doc, r.Items)
+	}
+	if err != nil {
+		t.List(nil, resp, err)
+		return
+	}
+	items, _ := doc.([]interface{})
+	if !tableOutputFormat() {
+		printOutput(items, nil)
+		return
+	}
+
+	b, _ := json.Marshal(items)
+	tbl, terr := newJSONTable(b, r.Columns, "")
+	switch {
+	case len(items) == 0:
+		fmt.Printf("%s\n", t.Title("There were no %s.", r.Name))
+	case terr != nil:
+		fmt.Printf("%s\n", t.Fail("The %s aren't a list of objects.", r.Name))
+	default:
+		t.List(tbl, resp, err)
+	}
+}
+
+// send does the single object requests and describes what comes back.
+func (r *resource) send(method, path string, body interface{}) {
+	doc, resp, err := r.request(method, path, body)
+	if err != nil {
+		t.Describe(nil, resp, err)
+		return
+	}
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		t.HTTPDisplay(resp, err)
+		return
+	}
+	rob := &resourceObject{obj: obj, keys: r.Fields}
+	if len(rob.keys) == 0 {
+		rob.keys = sortedKeys(obj)
+	}
+	printOutput(obj, func() { t.Describe(rob, resp, err) })
+}
+
+func (r *resource) delete(id string) {
+	_, resp, err := r.request(http.MethodDelete, r.itemPath(id), nil)
+	if err != nil {
+		t.Describe(nil, resp, err)
+		return
+	}
+	fmt.Printf("%s\n", t.Success("Deleted %s %s.", r.Singular, id))
+}
+
+// resourceObject displays one object with its fields down the page.
+type resourceObject struct {
+	obj  map[string]interface{}
+	keys []string
+}
+
+func (ro *resourceObject) Describe() {
+	w := ansiterm.NewTabWriter(os.Stdout, 4, 4, 2, ' ', 0)
+	for _, k := range ro.keys {
+		v, err := lookupPath(ro.obj, k)
+		val := t.Text("%s", jsonValueString(v))
+		if err != nil {
+			val = t.Text("-")
+		}
+		fmt.Fprintf(w, "%s\t%s\n", t.Title("%s:", k), val)
+	}
+	w.Flush()
+}
@@ -26,14 +26,22 @@ const (
 	commandline
 )
 
+// exitCode is set by commands that fail without an error, like --strict.
+var exitCode int
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	buildRoot(commandline)
+	readConfig(os.Args[1:])
+	buildAPIOperations(os.Args[1:])
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
 }
 
 // commands
@@ -42,6 +50,8 @@ var (
 	httpCmd                 *cobra.Command
 	listCmd, describeCmd    *cobra.Command
 	setCmd, showCmd         *cobra.Command
+	exportCmd, importCmd    *cobra.Command
+	saveCmd, deleteCmd      *cobra.Command
 )
 
 // This is pulled out specially, because for interactive
@@ -74,7 +84,14 @@ func buildRoot(mode runMode) {
 		Short: "Print version.",
 		Long:  "Every program needs a version, this shows you what the value is.",
 		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Printf("%s\n", version.Version)
+			v := version.Version
+			printOutput(map[string]interface{}{
+				"version": fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Dot),
+				"hash":    v.Hash,
+				"date":    v.Date,
+			}, func() {
+				fmt.Printf("%s\n", version.Version)
+			})
 		},
 	})
 
@@ -106,6 +123,34 @@ func buildRoot(mode runMode) {
 	}
 	rootCmd.AddCommand(showCmd)
 
+	saveCmd = &cobra.Command{
+		Use:   "save",
+		Short: "Save something to the config file",
+		Long:  "Keep something from the session in the config file, to use again later.",
+	}
+	rootCmd.AddCommand(saveCmd)
+
+	deleteCmd = &cobra.Command{
+		Use:   "delete",
+		Short: "Delete objects",
+		Long:  "Delete objects from the service, or things saved in the config file.",
+	}
+	rootCmd.AddCommand(deleteCmd)
+
+	exportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "Write session data to a file",
+		Long:  "Write what's happened in the session to a file in another tool's format.",
+	}
+	rootCmd.AddCommand(exportCmd)
+
+	importCmd = &cobra.Command{
+		Use:   "import",
+		Short: "Read a file from another tool",
+		Long:  "Read requests saved by another tool, to look at or send.",
+	}
+	rootCmd.AddCommand(importCmd)
+
 	httpCmd = &cobra.Command{
 		Use:   "http",
 		Short: "Use HTTP verbs.",
@@ -125,7 +170,7 @@ func buildRoot(mode runMode) {
 		Long:  "Display the flags for this appliation and their current settings.",
 		Run: func(cmd *cobra.Command, args []string) {
 			flags := rootCmd.LocalFlags()
-			printFlagSet(flags)
+			printOutput(flagViews(flags), func() { printFlagSet(flags) })
 		},
 	})
 
@@ -146,7 +191,7 @@ func buildRoot(mode runMode) {
 		Short:   "view configuration",
 		Long:    "Display the configuration information for this application as set by file, evnironment, flags",
 		Run: func(cmd *cobra.Command, args []string) {
-			printConfig()
+			printOutput(viper.AllSettings(), printConfig)
 		},
 	})
 
@@ -172,17 +217,35 @@ func buildRoot(mode runMode) {
 		Long:  "View name and attributes of the terminal profile that is current set.",
 		Run: func(cmd *cobra.Command, args []string) {
 			p := viper.GetString(t.ScreenProfileKey)
-			fmt.Printf("Screen profile is \"%s\": %s %s %s %s ",
-				p, t.Title("Title"), t.SubTitle("SubTitle"), t.Text("Text"), t.Highlight("Highlight"))
-			fmt.Printf("%s %s %s %s\n",
-				t.Success("Success"), t.Warn("Warn"), t.Fail("Fail"), t.Alert("Alert"))
+			printOutput(map[string]string{"screenProfile": p}, func() {
+				fmt.Printf("Screen profile is \"%s\": %s %s %s %s ",
+					p, t.Title("Title"), t.SubTitle("SubTitle"), t.Text("Text"), t.Highlight("Highlight"))
+				fmt.Printf("%s %s %s %s\n",
+					t.Success("Success"), t.Warn("Warn"), t.Fail("Fail"), t.Alert("Alert"))
+			})
 
 		},
 	})
 
 	// Build out sub menus.
 	buildHTTP(mode)
+	buildSnippets(mode)
+	buildCurl(mode)
 	buildConnection(mode)
+	buildJWT(mode)
+	buildTLS(mode)
+	buildStats(mode)
+	buildBench(mode)
+	buildScenario(mode)
+	buildResources(mode)
+	buildAPI(mode)
+	buildServe(mode)
+	buildHAR(mode)
+	buildHTTPFile(mode)
+	buildSavedRequests(mode)
+	buildVars(mode)
+	buildLast(mode)
+	buildCompletion(mode)
 }
 
 func displayFlags(fs *pflag.FlagSet) {
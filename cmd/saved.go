@@ -0,0 +1,470 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	connection "github.com/jdrivas/conman"
+	t "github.com/jdrivas/termtext"
+	config "github.com/jdrivas/vconfig"
+	"github.com/juju/ansiterm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
+)
+
+/*
+Saved Requests
+
+A request that's worth sending again can be saved under a name, right after sending it:
+
+	gafw http get /users/42
+	gafw save request get-user
+
+and it goes into the config file, where the rest of the team can get at it:
+
+requests:
+      get-user:
+            method: GET
+            path: /users/${id}          # ${name}s are filled in by run request
+            headers:
+                  accept: application/json
+            body: ""
+            connection: staging         # default is the current connection
+
+	gafw run request get-user id=42
+
+What's saved is the request as it was sent, less what the connection and the auth
+mode put on it again each time: the credentials (see sensitiveHeaders), the signing
+headers, the connection's own headers and the ones the transport looks after. Edit
+the path, headers or body in the file to make ${name}s of the parts that change.
+
+Saving rewrites the config file, and YAML comments don't survive that. It has to be
+a .yaml (or .yml) file, save and delete won't rewrite JSON or TOML.
+*/
+
+const requestsKey = "requests"
+
+type savedRequest struct {
+	Name       string            `json:"name"`
+	Method     string            `json:"method" mapstructure:"method"`
+	Path       string            `json:"path" mapstructure:"path"`
+	Headers    map[string]string `json:"headers,omitempty" mapstructure:"headers"`
+	Body       string            `json:"body,omitempty" mapstructure:"body"`
+	Connection string            `json:"connection,omitempty" mapstructure:"connection"`
+}
+
+var savedRequestAliases = []string{"req"}
+
+func buildSavedRequests(mode runMode) {
+	saveCmd.AddCommand(&cobra.Command{
+		Use:     "request <name>",
+		Aliases: savedRequestAliases,
+		Short:   "Save the last request sent under a name.",
+		Long:    "Save the last request sent this session in the config file, to send again with run request.",
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			r, err := saveRequest(args[0])
+			if err != nil {
+				fmt.Printf("%s\n", t.Error(err))
+				return
+			}
+			fmt.Printf("%s\n", t.Success("Saved %s %s as %s in %s.", r.Method, r.Path, r.Name, viper.ConfigFileUsed()))
+		},
+	})
+
+	listCmd.AddCommand(&cobra.Command{
+		Use:     "requests",
+		Aliases: []string{"reqs"},
+		Short:   "List the saved requests.",
+		Long:    "List the requests saved in the config file.",
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			rs, err := savedRequests()
+			if err != nil {
+				fmt.Printf("%s\n", t.Error(err))
+				return
+			}
+			printOutput(rs, func() { displaySavedRequests(rs) })
+		},
+	})
+
+	describeCmd.AddCommand(&cobra.Command{
+		Use:     "request <name> ...",
+		Aliases: savedRequestAliases,
+		Short:   "Details about saved requests.",
+		Long:    "Show everything about requests saved in the config file, and the ${name}s they need.",
+		Args:    cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			rs := []*savedRequest{}
+			for _, name := range args {
+				r, err := findSavedRequest(name)
+				if err != nil {
+					fmt.Printf("%s\n", t.Error(err))
+					return
+				}
+				rs = append(rs, r)
+			}
+			printOutput(rs, func() {
+				for _, r := range rs {
+					describeSavedRequest(r)
+				}
+			})
+		},
+	})
+
+	runCmd.AddCommand(&cobra.Command{
+		Use:     "request <name> [<var>=<value> ...]",
+		Aliases: savedRequestAliases,
+		Short:   "Send a saved request.",
+		Long:    "Send a request saved in the config file, filling in its ${name}s with the values given and the variables.",
+		Example: fmt.Sprintf("  %s run request get-user id=42", config.AppName),
+		Args:    cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runSavedRequest(args[0], args[1:]); err != nil {
+				fmt.Printf("%s\n", t.Error(err))
+			}
+		},
+	})
+
+	deleteCmd.AddCommand(&cobra.Command{
+		Use:     "request <name> ...",
+		Aliases: savedRequestAliases,
+		Short:   "Delete saved requests.",
+		Long:    "Take requests out of the config file.",
+		Args:    cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := deleteSavedRequests(args); err != nil {
+				fmt.Printf("%s\n", t.Error(err))
+			}
+		},
+	})
+}
+
+// Reading
+//
+
+// savedRequests are the saved requests, sorted by name.
+func savedRequests() ([]*savedRequest, error) {
+	byName := map[string]*savedRequest{}
+	if err := viper.UnmarshalKey(requestsKey, &byName); err != nil {
+		return nil, fmt.Errorf("the %s in the config file: %v", requestsKey, err)
+	}
+	rs := []*savedRequest{}
+	for name, r := range byName {
+		if r == nil {
+			r = &savedRequest{}
+		}
+		r.Name = name
+		if r.Method == "" {
+			r.Method = http.MethodGet
+		}
+		r.Method = strings.ToUpper(r.Method)
+		rs = append(rs, r)
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Name < rs[j].Name })
+	return rs, nil
+}
+
+// Viper keeps keys in lower case, so the names are too.
+func findSavedRequest(name string) (*savedRequest, error) {
+	rs, err := savedRequests()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rs {
+		if r.Name == strings.ToLower(name) {
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("there isn't a saved request named %q", name)
+}
+
+// Saving
+//
+
+// Headers that are put on the request again each time it's sent.
+var unsavedHeaders = []string{"Date", "Digest", "Content-Digest", "Signature", "Signature-Input", "X-Amz-Date", "X-Amz-Content-Sha256"}
+
+func saveRequest(name string) (*savedRequest, error) {
+	x, err := pickSent("last")
+	if err != nil {
+		return nil, err
+	}
+	in := x.interaction
+	if in.Request.Encoding != "" {
+		return nil, errors.New("the last request's body isn't text, so it can't go in the config file")
+	}
+	u, err := url.Parse(in.Request.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &savedRequest{Name: strings.ToLower(name), Method: in.Request.Method, Path: u.Path, Body: in.Request.Body, Connection: in.Connection}
+	var conn *connection.Connection
+	if in.Connection != "" {
+		conn = connection.GetAllConnections().FindConnection(in.Connection)
+	}
+	if conn != nil {
+		r.Path = servicePath(conn, u)
+	}
+	if u.RawQuery != "" {
+		r.Path += "?" + u.RawQuery
+	}
+	for k, vs := range in.Request.Headers {
+		if len(vs) == 0 || !savedHeader(k, vs[0], conn) {
+			continue
+		}
+		if r.Headers == nil {
+			r.Headers = map[string]string{}
+		}
+		r.Headers[strings.ToLower(k)] = strings.Join(vs, ", ")
+	}
+
+	rs, err := savedRequests()
+	if err != nil {
+		return nil, err
+	}
+	kept := []*savedRequest{r}
+	for _, o := range rs {
+		if o.Name != r.Name {
+			kept = append(kept, o)
+		}
+	}
+	return r, writeSavedRequests(kept)
+}
+
+func savedHeader(name, value string, conn *connection.Connection) bool {
+	name = http.CanonicalHeaderKey(name)
+	for _, hs := range [][]string{sensitiveHeaders, unsavedHeaders} {
+		for _, h := range hs {
+			if name == h {
+				return false
+			}
+		}
+	}
+	if isConnectionHeader(name) || (name == "User-Agent" && strings.HasPrefix(value, "Go-http-client/")) {
+		return false
+	}
+	if conn != nil {
+		for k, v := range conn.Headers {
+			if http.CanonicalHeaderKey(k) == name && v == value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func deleteSavedRequests(names []string) error {
+	rs, err := savedRequests()
+	if err != nil {
+		return err
+	}
+	gone := map[string]bool{}
+	for _, name := range names {
+		if _, err := findSavedRequest(name); err != nil {
+			return err
+		}
+		gone[strings.ToLower(name)] = true
+	}
+	kept := []*savedRequest{}
+	for _, r := range rs {
+		if !gone[r.Name] {
+			kept = append(kept, r)
+		}
+	}
+	return writeSavedRequests(kept)
+}
+
+// settings is the request as it goes in the config file.
+func (r *savedRequest) settings() map[string]interface{} {
+	s := map[string]interface{}{"method": r.Method, "path": r.Path}
+	if len(r.Headers) > 0 {
+		s["headers"] = r.Headers
+	}
+	if r.Body != "" {
+		s["body"] = r.Body
+	}
+	if r.Connection != "" {
+		s["connection"] = r.Connection
+	}
+	return s
+}
+
+// writeSavedRequests puts the requests in the config file, in place of the ones there,
+// and in viper for the rest of the session.
+func writeSavedRequests(rs []*savedRequest) error {
+	fn := viper.ConfigFileUsed()
+	if fn == "" {
+		return fmt.Errorf("there's no config file to save requests in, use --%s", configFlagKey)
+	}
+	if ext := strings.ToLower(filepath.Ext(fn)); ext != ".yaml" && ext != ".yml" {
+		return fmt.Errorf("can only save requests in a YAML config file, %s isn't one: add them to it by hand, or use a .yaml config", fn)
+	}
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return err
+	}
+	doc := yaml.MapSlice{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("reading %s: %v", fn, err)
+	}
+
+	settings := map[string]interface{}{}
+	for _, r := range rs {
+		settings[r.Name] = r.settings()
+	}
+	found := false
+	for i := range doc {
+		if k, ok := doc[i].Key.(string); ok && k == requestsKey {
+			doc[i].Value, found = settings, true
+		}
+	}
+	if !found {
+		doc = append(doc, yaml.MapItem{Key: requestsKey, Value: settings})
+	}
+
+	if b, err = yaml.Marshal(doc); err != nil {
+		return err
+	}
+	mode := os.FileMode(0600)
+	if fi, err := os.Stat(fn); err == nil {
+		mode = fi.Mode()
+	}
+	if err := ioutil.WriteFile(fn, b, mode); err != nil {
+		return err
+	}
+	viper.Set(requestsKey, settings)
+	return nil
+}
+
+// Running
+//
+
+func runSavedRequest(name string, assignments []string) error {
+	r, err := findSavedRequest(name)
+	if err != nil {
+		return err
+	}
+	vars := sessionVars()
+	for _, a := range assignments {
+		i := strings.Index(a, "=")
+		if i <= 0 {
+			return fmt.Errorf("%q isn't <var>=<value>", a)
+		}
+		vars[strings.ToLower(a[:i])] = a[i+1:]
+	}
+
+	conn, err := connection.GetCurrentConnection()
+	if r.Connection != "" {
+		if conn = connection.GetAllConnections().FindConnection(r.Connection); conn == nil {
+			return fmt.Errorf("request %s uses connection %q, and there isn't one", r.Name, r.Connection)
+		}
+	} else if err != nil {
+		return err
+	}
+
+	filled := r.filled(vars)
+	if missing := filled.vars(); len(missing) > 0 {
+		return fmt.Errorf("request %s needs values for: %s", r.Name, strings.Join(missing, ", "))
+	}
+	req, err := http.NewRequest(filled.Method, strings.TrimSuffix(conn.ServiceURL, "/")+filled.Path, strings.NewReader(filled.Body))
+	if err != nil {
+		return err
+	}
+	for k, v := range filled.Headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range conn.Headers {
+		if req.Header.Get(k) == "" {
+			req.Header.Set(k, v)
+		}
+	}
+	httpDisplay(sendRequest(withConnection(req, conn)))
+	return nil
+}
+
+// filled is a copy of the request with the ${name}s filled in from vars.
+func (r *savedRequest) filled(vars map[string]string) *savedRequest {
+	f := *r
+	f.Path = expandVars(r.Path, vars)
+	f.Body = expandVars(r.Body, vars)
+	f.Headers = map[string]string{}
+	for k, v := range r.Headers {
+		f.Headers[k] = expandVars(v, vars)
+	}
+	return &f
+}
+
+// vars are the ${name}s in the request, sorted.
+func (r *savedRequest) vars() []string {
+	seen := map[string]bool{}
+	names := []string{}
+	add := func(s string) {
+		for _, m := range varRE.FindAllStringSubmatch(s, -1) {
+			if _, gen := generators[m[1]]; gen {
+				continue
+			}
+			if !seen[m[1]] {
+				seen[m[1]] = true
+				names = append(names, m[1])
+			}
+		}
+	}
+	add(r.Path)
+	add(r.Body)
+	for _, v := range r.Headers {
+		add(v)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Display
+//
+
+func displaySavedRequests(rs []*savedRequest) {
+	if len(rs) == 0 {
+		fmt.Printf("%s\n", t.Title("There aren't any saved requests."))
+		return
+	}
+	w := ansiterm.NewTabWriter(os.Stdout, 4, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\n", t.Title("Name\tMethod\tPath\tConnection\tVars"))
+	for _, r := range rs {
+		fmt.Fprintf(w, "%s\n", t.Text("%s\t%s\t%s\t%s\t%s", r.Name, r.Method, r.Path, defaultString(r.Connection, "(current)"), strings.Join(r.vars(), ", ")))
+	}
+	w.Flush()
+}
+
+func describeSavedRequest(r *savedRequest) {
+	fmt.Printf("%s %s\n", t.Title("Request:"), t.Highlight(r.Name))
+	fmt.Printf("%s %s\n", t.Title("Send:"), t.Text("%s %s", r.Method, r.Path))
+	fmt.Printf("%s %s\n", t.Title("Connection:"), t.Text("%s", defaultString(r.Connection, "(current)")))
+	if vars := r.vars(); len(vars) > 0 {
+		fmt.Printf("%s %s\n", t.Title("Vars:"), t.Text("%s", strings.Join(vars, ", ")))
+	}
+	if len(r.Headers) > 0 {
+		keys := []string{}
+		for k := range r.Headers {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		w := ansiterm.NewTabWriter(os.Stdout, 4, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "%s\n", t.Title("Header\tValue"))
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s\n", t.Text("%s\t%s", http.CanonicalHeaderKey(k), r.Headers[k]))
+		}
+		w.Flush()
+	}
+	if r.Body != "" {
+		fmt.Printf("%s\n%s\n", t.Title("Body:"), t.Text("%s", r.Body))
+	}
+}
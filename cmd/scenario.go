@@ -0,0 +1,460 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	connection "github.com/jdrivas/conman"
+	t "github.com/jdrivas/termtext"
+	config "github.com/jdrivas/vconfig"
+	"github.com/juju/ansiterm"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+/*
+Scenarios
+
+A scenario is a load test of more than one request. Virtual users (VUs) each
+loop: pick a flow by weight, run its steps in order, repeat. The number of
+VUs follows the stages, moving linearly from where the last stage left off
+to the stage's target.
+
+stages:
+  - duration: 1m      # ramp up to 50 VUs
+    target: 50
+  - duration: 5m      # hold
+    target: 50
+  - duration: 30s     # ramp down
+    target: 0
+flows:
+  - name: browse
+    weight: 3
+    steps:
+      - name: list users
+        method: get
+        path: /users
+        extract:
+          userId: $[0].id           # a path into the JSON response body, or
+          next: header:Location     # a response header.
+      - method: get
+        path: /users/${userId}
+        think: 500ms                # pause after the step
+  - name: signup
+    weight: 1
+    steps:
+      - method: post
+        path: /users
+        body: {name: "load test"}
+
+If there's just the one flow, steps can go at the top level instead of flows.
+Extracted variables belong to the VU and carry from one step to the next.
+A step whose extraction fails counts as an error and ends that run of the flow.
+*/
+
+func buildScenario(mode runMode) {
+	httpCmd.AddCommand(&cobra.Command{
+		Use:   "scenario <scenario-file>",
+		Short: "Run a multi-step load test scenario.",
+		Long: `Runs the scenario file against the current connection, ramping virtual users
+up and down through its stages, then reports on each step.
+
+Use -o json for results you can keep to compare runs.`,
+		Example: fmt.Sprintf("%s -c staging http scenario checkout.yaml", config.AppName),
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			conn, err := connection.GetCurrentConnection()
+			if err == nil {
+				var sc *scenario
+				if sc, err = readScenario(args[0]); err == nil {
+					r := sc.run(conn, tableOutputFormat())
+					printOutput(r, r.Describe)
+				}
+			}
+			if err != nil {
+				fmt.Printf("%s\n", t.Error(err))
+			}
+		},
+	})
+}
+
+// Scenario file
+//
+
+type scenario struct {
+	Stages []scenarioStage `yaml:"stages"`
+	Flows  []scenarioFlow  `yaml:"flows"`
+	Steps  []scenarioStep  `yaml:"steps"`
+}
+
+type scenarioStage struct {
+	Duration time.Duration `yaml:"duration"`
+	Target   int           `yaml:"target"`
+}
+
+type scenarioFlow struct {
+	Name   string         `yaml:"name"`
+	Weight int            `yaml:"weight"`
+	Steps  []scenarioStep `yaml:"steps"`
+}
+
+type scenarioStep struct {
+	Name    string            `yaml:"name"`
+	Method  string            `yaml:"method"`
+	Path    string            `yaml:"path"`
+	Body    interface{}       `yaml:"body"`
+	Extract map[string]string `yaml:"extract"`
+	Think   time.Duration     `yaml:"think"`
+
+	body  string // Body as JSON, ready for variables.
+	index int    // Where the step's results go.
+}
+
+const extractHeaderPrefix = "header:"
+
+func readScenario(fn string) (sc *scenario, err error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	sc = &scenario{}
+	if err = yaml.UnmarshalStrict(b, sc); err != nil {
+		return nil, fmt.Errorf("reading scenario %s: %v", fn, err)
+	}
+
+	if len(sc.Steps) > 0 {
+		sc.Flows = append(sc.Flows, scenarioFlow{Name: "default", Steps: sc.Steps})
+		sc.Steps = nil
+	}
+	if len(sc.Flows) == 0 {
+		return nil, fmt.Errorf("scenario %s has no steps", fn)
+	}
+	if len(sc.Stages) == 0 {
+		return nil, fmt.Errorf("scenario %s has no stages", fn)
+	}
+	for _, s := range sc.Stages {
+		if s.Duration <= 0 || s.Target < 0 {
+			return nil, fmt.Errorf("scenario %s: stages need a duration and a target of 0 or more", fn)
+		}
+	}
+
+	index := 0
+	for i := range sc.Flows {
+		f := &sc.Flows[i]
+		if f.Weight == 0 {
+			f.Weight = 1
+		}
+		if f.Weight < 0 || len(f.Steps) == 0 {
+			return nil, fmt.Errorf("scenario %s: flow %q needs steps and a positive weight", fn, f.Name)
+		}
+		for j := range f.Steps {
+			s := &f.Steps[j]
+			if s.Method == "" || s.Path == "" {
+				return nil, fmt.Errorf("scenario %s: flow %q step %d needs a method and a path", fn, f.Name, j+1)
+			}
+			s.Method = strings.ToUpper(s.Method)
+			if s.Name == "" {
+				s.Name = fmt.Sprintf("%s %s", s.Method, s.Path)
+			}
+			if s.body, err = scenarioBody(s.Body); err != nil {
+				return nil, fmt.Errorf("scenario %s: step %q: %v", fn, s.Name, err)
+			}
+			s.index = index
+			index++
+		}
+	}
+	return sc, nil
+}
+
+// scenarioBody turns the YAML body into JSON text.
+// A string is taken to be JSON already.
+func scenarioBody(body interface{}) (string, error) {
+	switch b := body.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return b, nil
+	}
+	j, err := json.Marshal(yamlToJSON(body))
+	return string(j), err
+}
+
+// yamlToJSON converts the map[interface{}]interface{}s that yaml gives us to
+// map[string]interface{}s so they'll marshal.
+func yamlToJSON(v interface{}) interface{} {
+	switch c := v.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, v := range c {
+			m[fmt.Sprintf("%v", k)] = yamlToJSON(v)
+		}
+		return m
+	case []interface{}:
+		for i := range c {
+			c[i] = yamlToJSON(c[i])
+		}
+	}
+	return v
+}
+
+// Running
+//
+
+// How often we adjust the number of VUs and, on the terminal, report progress.
+const (
+	scenarioTick     = 100 * time.Millisecond
+	scenarioProgress = 10 * time.Second
+)
+
+type scenarioRun struct {
+	sc      *scenario
+	conn    *connection.Connection
+	weights int
+
+	mu      sync.Mutex
+	results [][]benchResult // By step index.
+	count   int
+}
+
+func (sc *scenario) run(conn *connection.Connection, progress bool) *scenarioReport {
+	r := &scenarioRun{sc: sc, conn: conn}
+	for _, f := range sc.Flows {
+		r.weights += f.Weight
+		r.results = append(r.results, make([][]benchResult, len(f.Steps))...)
+	}
+
+	var wg sync.WaitGroup
+	var vus []chan struct{}
+	maxVUs := 0
+
+	start := time.Now()
+	lastProgress := start
+	ticker := time.NewTicker(scenarioTick)
+	defer ticker.Stop()
+	for now := start; ; now = <-ticker.C {
+		target, done := sc.target(now.Sub(start))
+		if done {
+			break
+		}
+		for len(vus) < target {
+			stop := make(chan struct{})
+			vus = append(vus, stop)
+			wg.Add(1)
+			go func(seed int64) {
+				defer wg.Done()
+				r.vu(stop, rand.New(rand.NewSource(seed)))
+			}(now.UnixNano() + int64(len(vus)))
+		}
+		for len(vus) > target {
+			close(vus[len(vus)-1])
+			vus = vus[:len(vus)-1]
+		}
+		maxVUs = maxInt(maxVUs, len(vus))
+
+		if progress && now.Sub(lastProgress) >= scenarioProgress {
+			lastProgress = now
+			r.mu.Lock()
+			n := r.count
+			r.mu.Unlock()
+			fmt.Printf("%s\n", t.Info("%s: %d VUs, %d requests", now.Sub(start).Truncate(time.Second), len(vus), n))
+		}
+	}
+	for _, stop := range vus {
+		close(stop)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report := &scenarioReport{Connection: conn.Name, Seconds: elapsed.Seconds(), MaxVUs: maxVUs}
+	var all []benchResult
+	for _, f := range sc.Flows {
+		for _, s := range f.Steps {
+			res := r.results[s.index]
+			all = append(all, res...)
+			br := newBenchReport(conn, s.Method, s.Path, benchOptions{Concurrency: maxVUs}, elapsed, res)
+			report.Steps = append(report.Steps, scenarioStepReport{Flow: f.Name, Step: s.Name, benchReport: br})
+		}
+	}
+	report.Total = newBenchReport(conn, "", "", benchOptions{Concurrency: maxVUs}, elapsed, all)
+	return report
+}
+
+// target is the number of VUs we should have at d into the run,
+// done once we're past the last stage.
+func (sc *scenario) target(d time.Duration) (vus int, done bool) {
+	from := 0
+	for _, s := range sc.Stages {
+		if d < s.Duration {
+			return from + int(float64(s.Target-from)*float64(d)/float64(s.Duration)), false
+		}
+		d -= s.Duration
+		from = s.Target
+	}
+	return 0, true
+}
+
+// vu runs flows until told to stop.
+func (r *scenarioRun) vu(stop chan struct{}, rnd *rand.Rand) {
+	vars := map[string]string{}
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		f := r.pickFlow(rnd)
+		for _, s := range f.Steps {
+			res, ok := r.step(s, vars)
+			r.mu.Lock()
+			r.results[s.index] = append(r.results[s.index], res)
+			r.count++
+			r.mu.Unlock()
+			if !ok {
+				break
+			}
+			if s.Think > 0 {
+				select {
+				case <-stop:
+					return
+				case <-time.After(s.Think):
+				}
+			}
+		}
+	}
+}
+
+func (r *scenarioRun) pickFlow(rnd *rand.Rand) scenarioFlow {
+	n := rnd.Intn(r.weights)
+	for _, f := range r.sc.Flows {
+		if n < f.Weight {
+			return f
+		}
+		n -= f.Weight
+	}
+	return r.sc.Flows[0]
+}
+
+// step sends the request and does the extractions.
+// ok is false if the rest of the flow shouldn't run.
+func (r *scenarioRun) step(s scenarioStep, vars map[string]string) (res benchResult, ok bool) {
+	var body interface{}
+	if s.body != "" {
+		body = expandVars(s.body, vars)
+	}
+	_, resp, err := r.conn.Send(s.Method, expandVars(s.Path, vars), body, nil)
+	if resp == nil {
+		res.Err = err
+		return res, false
+	}
+	defer resp.Body.Close()
+	res.Status = resp.StatusCode
+	if ex := responseExchange(resp); ex != nil {
+		res.Latency = ex.Timing.phases().Total
+	}
+	if len(s.Extract) > 0 {
+		if res.Err = extractVars(s.Extract, resp, vars); res.Err != nil {
+			return res, false
+		}
+	}
+	return res, true
+}
+
+func extractVars(extract map[string]string, resp *http.Response, vars map[string]string) error {
+	var doc interface{}
+	var docErr error
+	read := false
+	for name, from := range extract {
+		if strings.HasPrefix(from, extractHeaderPrefix) {
+			h := strings.TrimSpace(from[len(extractHeaderPrefix):])
+			v := resp.Header.Get(h)
+			if v == "" {
+				return fmt.Errorf("extracting %s: no %s header", name, h)
+			}
+			vars[name] = v
+			continue
+		}
+		if !read {
+			read = true
+			if b, err := ioutil.ReadAll(resp.Body); err != nil {
+				docErr = err
+			} else {
+				docErr = json.Unmarshal(b, &doc)
+			}
+		}
+		if docErr != nil {
+			return fmt.Errorf("extracting %s: %v", name, docErr)
+		}
+		v, err := lookupPath(doc, from)
+		if err != nil {
+			return fmt.Errorf("extracting %s: %v", name, err)
+		}
+		vars[name] = jsonValueString(v)
+	}
+	return nil
+}
+
+// Report
+//
+
+type scenarioReport struct {
+	Connection string               `json:"connection"`
+	Seconds    float64              `json:"seconds"`
+	MaxVUs     int                  `json:"maxVUs"`
+	Steps      []scenarioStepReport `json:"steps"`
+	Total      *benchReport         `json:"total"`
+}
+
+type scenarioStepReport struct {
+	Flow string `json:"flow"`
+	Step string `json:"step"`
+	*benchReport
+}
+
+// Describe prints a line for each step and one for the lot.
+func (r *scenarioReport) Describe() {
+	fmt.Printf("%s\n\n", t.Title("Scenario on %s: %.1fs, up to %d VUs", r.Connection, r.Seconds, r.MaxVUs))
+	w := ansiterm.NewTabWriter(os.Stdout, 4, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\n", t.Title("Flow\tStep\tRequests\tErrors\tReq/s\tp50\tp90\tp99\tMax\tStatus Codes"))
+	row := func(flow, step string, b *benchReport) {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", t.Title("%s", flow), t.Text("%s", step),
+			t.Text("%d", b.Requests), errorCount(b.Errors), t.Text("%.1f", b.RequestsPerSec),
+			t.Text("%.1fms", b.P50), t.Text("%.1fms", b.P90), t.Text("%.1fms", b.P99), t.Text("%.1fms", b.Max),
+			statusCodesText(b.StatusCodes))
+	}
+	for _, s := range r.Steps {
+		row(s.Flow, s.Step, s.benchReport)
+	}
+	row("", "Total", r.Total)
+	w.Flush()
+
+	if r.Total.Errors > 0 {
+		fmt.Printf("\n%s\n", t.Fail("Errors"))
+		for _, s := range r.Steps {
+			for m, n := range s.ErrorMessages {
+				fmt.Printf("  %s %s %s\n", t.Title("%s:", s.Step), t.Text("%d", n), t.Fail("%s", m))
+			}
+		}
+	}
+}
+
+func errorCount(n int) string {
+	if n > 0 {
+		return t.Fail("%d", n)
+	}
+	return t.Text("%d", n)
+}
+
+func statusCodesText(codes map[int]int) string {
+	var s []string
+	for _, c := range sortedStatusCodes(codes) {
+		s = append(s, fmt.Sprintf("%d:%d", c, codes[c]))
+	}
+	return t.Text("%s", strings.Join(s, " "))
+}
@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var serveCmd *cobra.Command
+
+// Local servers that stand in for, or in front of, a service.
+func buildServe(mode runMode) {
+	serveCmd = &cobra.Command{
+		Use:   "serve",
+		Short: "Run a local server",
+		Long:  "Run a local HTTP server to stand in for, or in front of, a service.",
+	}
+	rootCmd.AddCommand(serveCmd)
+
+	buildMock(mode)
+	buildProxy(mode)
+}
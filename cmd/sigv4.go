@@ -0,0 +1,350 @@
+package cmd
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	connection "github.com/jdrivas/conman"
+	t "github.com/jdrivas/termtext"
+	"github.com/juju/ansiterm"
+)
+
+/*
+AWS Signature Version 4
+
+Configured on a connection with auth mode sigv4:
+
+            auth:
+                  mode: sigv4
+                  region: us-west-2          # or AWS_REGION, AWS_DEFAULT_REGION
+                  service: execute-api
+                  profile: default           # credential store profile, or AWS_PROFILE
+                  unsignedPayload: false     # true to skip hashing the body
+
+Credentials come from the environment (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+AWS_SESSION_TOKEN) and if they aren't set there, from the shared credential
+store (~/.aws/credentials or AWS_SHARED_CREDENTIALS_FILE).
+
+See: https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+*/
+
+// SigV4 configuration keys, relative to the connection auth configuration.
+const (
+	sigV4RegionKey          = "region"          // string
+	sigV4ServiceKey         = "service"         // string
+	sigV4ProfileKey         = "profile"         // string
+	sigV4UnsignedPayloadKey = "unsignedPayload" // bool
+)
+
+const (
+	sigV4Algorithm       = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat      = "20060102T150405Z"
+	sigV4DateFormat      = "20060102"
+	sigV4UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+type awsCredentials struct {
+	AccessKeyID, SecretAccessKey, SessionToken string
+	Source                                     string
+}
+
+// sigV4Details captures the intermediate signing values.
+// The canonical request is what you want when the service says
+// the signatures don't match.
+type sigV4Details struct {
+	Credentials      awsCredentials
+	Scope            string
+	PayloadHash      string
+	CanonicalRequest string
+	StringToSign     string
+	Signature        string
+	Authorization    string
+}
+
+func signSigV4(conn *connection.Connection, req *http.Request) (signingDetails, error) {
+	region := authString(conn, sigV4RegionKey)
+	if region == "" {
+		region = firstEnv("AWS_REGION", "AWS_DEFAULT_REGION")
+	}
+	service := authString(conn, sigV4ServiceKey)
+	if region == "" || service == "" {
+		return nil, fmt.Errorf("sigv4 on connection %q needs both a region and a service", conn.Name)
+	}
+
+	creds, err := getAWSCredentials(authString(conn, sigV4ProfileKey))
+	if err != nil {
+		return nil, err
+	}
+
+	payloadHash := sigV4UnsignedPayload
+	if !authBool(conn, sigV4UnsignedPayloadKey) {
+		body, err := requestBody(req)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read request body for signing: %v", err)
+		}
+		payloadHash = hashHex(body)
+	}
+
+	now := time.Now().UTC()
+	d := &sigV4Details{
+		Credentials: creds,
+		PayloadHash: payloadHash,
+		Scope:       strings.Join([]string{now.Format(sigV4DateFormat), region, service, "aws4_request"}, "/"),
+	}
+
+	// Headers have to be in place before they get signed.
+	req.Header.Del("Authorization")
+	req.Header.Set("X-Amz-Date", now.Format(sigV4TimeFormat))
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	if service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	signedHeaders, canonicalHeaders := sigV4CanonicalHeaders(req)
+	d.CanonicalRequest = strings.Join([]string{
+		req.Method,
+		sigV4CanonicalURI(req, service != "s3"),
+		sigV4CanonicalQuery(req),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	d.StringToSign = strings.Join([]string{
+		sigV4Algorithm,
+		now.Format(sigV4TimeFormat),
+		d.Scope,
+		hashHex([]byte(d.CanonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), []byte(now.Format(sigV4DateFormat)))
+	for _, s := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, []byte(s))
+	}
+	d.Signature = hex.EncodeToString(hmacSHA256(key, []byte(d.StringToSign)))
+
+	d.Authorization = fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, creds.AccessKeyID, d.Scope, signedHeaders, d.Signature)
+	req.Header.Set("Authorization", d.Authorization)
+
+	return d, nil
+}
+
+// Headers that are left out of the signature. The Go client may change
+// or add these after we've signed.
+var sigV4SkipHeaders = map[string]bool{
+	"authorization":   true,
+	"user-agent":      true,
+	"accept-encoding": true,
+	"content-length":  true,
+	"x-amzn-trace-id": true,
+}
+
+// Returns the signed header list and the canonical header block.
+func sigV4CanonicalHeaders(req *http.Request) (signed, canonical string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	hm := map[string]string{"host": host}
+	for k, vs := range req.Header {
+		lk := strings.ToLower(k)
+		if sigV4SkipHeaders[lk] {
+			continue
+		}
+		var tvs []string
+		for _, v := range vs {
+			tvs = append(tvs, strings.Join(strings.Fields(v), " "))
+		}
+		hm[lk] = strings.Join(tvs, ",")
+	}
+
+	keys := []string{}
+	for k := range hm {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s:%s\n", k, hm[k])
+	}
+	return strings.Join(keys, ";"), b.String()
+}
+
+// S3 encodes the path once, everyone else encodes it twice.
+func sigV4CanonicalURI(req *http.Request, doubleEncode bool) string {
+	p := req.URL.EscapedPath()
+	if !doubleEncode {
+		p = req.URL.Path
+	}
+	if p == "" {
+		return "/"
+	}
+	segs := strings.Split(p, "/")
+	for i, s := range segs {
+		segs[i] = sigV4Escape(s)
+	}
+	return strings.Join(segs, "/")
+}
+
+func sigV4CanonicalQuery(req *http.Request) string {
+	q := req.URL.Query()
+	keys := []string{}
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, k := range keys {
+		vs := q[k]
+		sort.Strings(vs)
+		for _, v := range vs {
+			params = append(params, sigV4Escape(k)+"="+sigV4Escape(v))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// URI encode everything but the unreserved characters.
+// url.QueryEscape doesn't do what AWS wants with spaces or '~'.
+func sigV4Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// Credentials
+//
+
+// getAWSCredentials looks in the environment and then the shared credential store.
+func getAWSCredentials(profile string) (creds awsCredentials, err error) {
+	creds = awsCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Source:          "environment",
+	}
+	if creds.AccessKeyID != "" && creds.SecretAccessKey != "" {
+		return creds, nil
+	}
+
+	if profile == "" {
+		profile = firstEnv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+	fn := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if fn == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return creds, err
+		}
+		fn = filepath.Join(home, ".aws", "credentials")
+	}
+
+	values, err := readINISection(fn, profile)
+	if err != nil {
+		return creds, fmt.Errorf("no AWS credentials in the environment and couldn't read credential store: %v", err)
+	}
+	creds = awsCredentials{
+		AccessKeyID:     values["aws_access_key_id"],
+		SecretAccessKey: values["aws_secret_access_key"],
+		SessionToken:    values["aws_session_token"],
+		Source:          fmt.Sprintf("%s [%s]", fn, profile),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		err = fmt.Errorf("profile %q in %s doesn't have an access key and secret", profile, fn)
+	}
+	return creds, err
+}
+
+// Just enough INI to read the AWS credential file.
+func readINISection(fn, section string) (values map[string]string, err error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values = make(map[string]string)
+	found, in := false, false
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			in = strings.TrimSpace(line[1:len(line)-1]) == section
+			found = found || in
+		case in:
+			if kv := strings.SplitN(line, "=", 2); len(kv) == 2 {
+				values[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+			}
+		}
+	}
+	if err = s.Err(); err == nil && !found {
+		err = fmt.Errorf("profile %q not found in %s", section, fn)
+	}
+	return values, err
+}
+
+// Display
+//
+
+// Describe prints out the signing steps.
+func (d *sigV4Details) Describe() {
+	w := ansiterm.NewTabWriter(os.Stdout, 4, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\n", t.Title("SigV4\t"))
+	fmt.Fprintf(w, "%s\t%s\n", t.Title("Access Key:"), t.Text("%s (%s)", d.Credentials.AccessKeyID, d.Credentials.Source))
+	fmt.Fprintf(w, "%s\t%s\n", t.Title("Scope:"), t.Text("%s", d.Scope))
+	fmt.Fprintf(w, "%s\t%s\n", t.Title("Payload Hash:"), t.Text("%s", d.PayloadHash))
+	fmt.Fprintf(w, "%s\t%s\n", t.Title("Signature:"), t.Text("%s", d.Signature))
+	w.Flush()
+	fmt.Printf("%s\n%s\n", t.Title("Canonical Request:"), t.Text("%s", d.CanonicalRequest))
+	fmt.Printf("%s\n%s\n", t.Title("String to Sign:"), t.Text("%s", d.StringToSign))
+}
+
+// Util
+//
+
+func hashHex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write(data)
+	return m.Sum(nil)
+}
+
+// firstEnv returns the first non-empty environment variable.
+func firstEnv(names ...string) string {
+	for _, n := range names {
+		if v := os.Getenv(n); v != "" {
+			return v
+		}
+	}
+	return ""
+}
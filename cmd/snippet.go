@@ -0,0 +1,318 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	t "github.com/jdrivas/termtext"
+	config "github.com/jdrivas/vconfig"
+	"github.com/spf13/cobra"
+)
+
+/*
+Request Snippets
+
+http export turns a request sent this session into something that runs without us,
+for handing a reproduction to someone who doesn't use gafw:
+
+	gafw http export                 the last request, as curl
+	gafw http export 2 --as python   the one before that, with python requests
+
+The request is the one the transport sent (see history.go): the connection's headers
+and the auth mode's signing are in it. Credentials (see sensitiveHeaders) come out
+as REDACTED unless you ask for them with --secrets. A signature is only good for a
+while, so a signed request will need signing again eventually anyway.
+
+Bodies that aren't text are carried as base64 and decoded by the snippet.
+*/
+
+var httpExportCmd *cobra.Command
+
+// The snippet formats, in the order we list them.
+var snippetFormats = []string{"curl", "httpie", "go", "python", "js-fetch"}
+
+var snippetWriters = map[string]func(*snippetRequest) string{
+	"curl":     curlSnippet,
+	"httpie":   httpieSnippet,
+	"go":       goSnippet,
+	"python":   pythonSnippet,
+	"js-fetch": fetchSnippet,
+}
+
+func buildSnippets(mode runMode) {
+	httpExportCmd = &cobra.Command{
+		Use:   "export [last|<n>] [flags]",
+		Short: "Show a request sent this session as curl or code.",
+		Long: fmt.Sprintf(`Write out a request sent this session as a command or code that sends it
+without %s. last is the last request sent (the default), <n> counts back from it,
+so 1 is the last one too and 2 is the one before.
+Credentials are REDACTED unless you use --secrets.`, config.AppName),
+		Example: fmt.Sprintf("  %s http export\n  %s http export 2 --as python", config.AppName, config.AppName),
+		Args:    cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			which := "last"
+			if len(args) > 0 {
+				which = args[0]
+			}
+			if err := exportSnippet(which); err != nil {
+				fmt.Printf("%s\n", t.Error(err))
+			}
+		},
+	}
+	httpCmd.AddCommand(httpExportCmd)
+	initHTTPExportFlags()
+}
+
+// Export flags
+//
+
+var (
+	snippetAsFlag      string
+	snippetSecretsFlag bool
+)
+
+const (
+	snippetAsFlagKey      = "as"
+	snippetSecretsFlagKey = "secrets"
+)
+
+func initHTTPExportFlags() {
+	httpExportCmd.Flags().StringVar(&snippetAsFlag, snippetAsFlagKey, "curl", fmt.Sprintf("What to write the request as: %s.", strings.Join(snippetFormats, ", ")))
+	httpExportCmd.Flags().BoolVar(&snippetSecretsFlag, snippetSecretsFlagKey, false, "Include the credentials instead of REDACTED.")
+}
+
+func exportSnippet(which string) error {
+	write, ok := snippetWriters[strings.ToLower(snippetAsFlag)]
+	if !ok {
+		return fmt.Errorf("can't write a request as %q, use one of: %s", snippetAsFlag, strings.Join(snippetFormats, ", "))
+	}
+	x, err := pickSent(which)
+	if err != nil {
+		return err
+	}
+	in := x.interaction
+	if !snippetSecretsFlag {
+		in = in.redacted()
+	}
+	r, err := newSnippetRequest(in)
+	if err != nil {
+		return err
+	}
+	fmt.Print(write(r))
+	if !snippetSecretsFlag && r.hasRedacted() {
+		fmt.Fprintf(os.Stderr, "%s\n", t.Info("Credentials are %s, use --%s to include them.", redacted, snippetSecretsFlagKey))
+	}
+	return nil
+}
+
+// pickSent finds a request in the history, counting back from the last one.
+func pickSent(which string) (*sentExchange, error) {
+	sent := history.all()
+	if len(sent) == 0 {
+		return nil, errors.New("no requests have been sent this session")
+	}
+	n := 1
+	if which != "last" {
+		var err error
+		if n, err = strconv.Atoi(which); err != nil || n < 1 {
+			return nil, fmt.Errorf("%q isn't last or a number of requests back from it", which)
+		}
+	}
+	if n > len(sent) {
+		return nil, fmt.Errorf("only %d requests have been sent this session", len(sent))
+	}
+	return sent[len(sent)-n], nil
+}
+
+type snippetRequest struct {
+	Method  string
+	URL     string
+	Headers [][2]string // Name, value in order.
+	Body    string
+	Binary  bool // Body is base64.
+}
+
+func newSnippetRequest(in *interaction) (*snippetRequest, error) {
+	r := &snippetRequest{Method: strings.ToUpper(in.Request.Method), URL: in.Request.URL, Body: in.Request.Body, Binary: in.Request.Encoding == "base64"}
+	keys := []string{}
+	for k := range in.Request.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if isConnectionHeader(k) {
+			continue
+		}
+		for _, v := range in.Request.Headers[k] {
+			r.Headers = append(r.Headers, [2]string{k, v})
+		}
+	}
+	if r.Binary {
+		if _, err := base64.StdEncoding.DecodeString(r.Body); err != nil {
+			return nil, fmt.Errorf("the request body: %v", err)
+		}
+	}
+	return r, nil
+}
+
+func (r *snippetRequest) hasRedacted() bool {
+	if strings.Contains(r.URL, redacted) || strings.Contains(r.Body, redacted) {
+		return true
+	}
+	for _, h := range r.Headers {
+		if h[1] == redacted {
+			return true
+		}
+	}
+	return false
+}
+
+// Formats
+//
+
+func curlSnippet(r *snippetRequest) string {
+	var b strings.Builder
+	if r.Binary {
+		fmt.Fprintf(&b, "echo %s | base64 -d | ", shellQuote(r.Body))
+	}
+	b.WriteString("curl")
+	if r.Method != "GET" || r.Body != "" {
+		fmt.Fprintf(&b, " -X %s", r.Method)
+	}
+	fmt.Fprintf(&b, " %s", shellQuote(r.URL))
+	for _, h := range r.Headers {
+		fmt.Fprintf(&b, " \\\n  -H %s", shellQuote(h[0]+": "+h[1]))
+	}
+	switch {
+	case r.Binary:
+		b.WriteString(" \\\n  --data-binary @-")
+	case r.Body != "":
+		fmt.Fprintf(&b, " \\\n  --data-raw %s", shellQuote(r.Body))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+func httpieSnippet(r *snippetRequest) string {
+	var b strings.Builder
+	switch {
+	case r.Binary:
+		fmt.Fprintf(&b, "echo %s | base64 -d | ", shellQuote(r.Body))
+	case r.Body != "":
+		fmt.Fprintf(&b, "printf '%%s' %s | ", shellQuote(r.Body))
+	}
+	fmt.Fprintf(&b, "http %s %s", r.Method, shellQuote(r.URL))
+	for _, h := range r.Headers {
+		fmt.Fprintf(&b, " \\\n  %s", shellQuote(h[0]+":"+h[1]))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+func goSnippet(r *snippetRequest) string {
+	var b strings.Builder
+	imports := []string{"fmt", "io/ioutil", "net/http"}
+	body := "nil"
+	switch {
+	case r.Binary:
+		imports = append(imports, "bytes", "encoding/base64")
+		body = "bytes.NewReader(body)"
+	case r.Body != "":
+		imports = append(imports, "strings")
+		body = fmt.Sprintf("strings.NewReader(%s)", strconv.Quote(r.Body))
+	}
+	sort.Strings(imports)
+	b.WriteString("package main\n\nimport (\n")
+	for _, i := range imports {
+		fmt.Fprintf(&b, "\t%q\n", i)
+	}
+	b.WriteString(")\n\nfunc main() {\n")
+	if r.Binary {
+		fmt.Fprintf(&b, "\tbody, err := base64.StdEncoding.DecodeString(%q)\n\tif err != nil {\n\t\tpanic(err)\n\t}\n", r.Body)
+	}
+	fmt.Fprintf(&b, "\treq, err := http.NewRequest(%q, %q, %s)\n\tif err != nil {\n\t\tpanic(err)\n\t}\n", r.Method, r.URL, body)
+	for _, h := range r.Headers {
+		fmt.Fprintf(&b, "\treq.Header.Add(%q, %q)\n", h[0], h[1])
+	}
+	b.WriteString("\n\tresp, err := http.DefaultClient.Do(req)\n\tif err != nil {\n\t\tpanic(err)\n\t}\n")
+	b.WriteString("\tdefer resp.Body.Close()\n\tb, err := ioutil.ReadAll(resp.Body)\n\tif err != nil {\n\t\tpanic(err)\n\t}\n")
+	b.WriteString("\tfmt.Println(resp.Status)\n\tfmt.Println(string(b))\n}\n")
+	return b.String()
+}
+
+func pythonSnippet(r *snippetRequest) string {
+	var b strings.Builder
+	if r.Binary {
+		b.WriteString("import base64\n")
+	}
+	b.WriteString("import requests\n\nresp = requests.request(\n")
+	fmt.Fprintf(&b, "    %s,\n    %s,\n", jsString(r.Method), jsString(r.URL))
+	if len(r.Headers) > 0 {
+		b.WriteString("    headers={\n")
+		for _, h := range joinedHeaders(r.Headers) {
+			fmt.Fprintf(&b, "        %s: %s,\n", jsString(h[0]), jsString(h[1]))
+		}
+		b.WriteString("    },\n")
+	}
+	switch {
+	case r.Binary:
+		fmt.Fprintf(&b, "    data=base64.b64decode(%s),\n", jsString(r.Body))
+	case r.Body != "":
+		fmt.Fprintf(&b, "    data=%s.encode(\"utf-8\"),\n", jsString(r.Body))
+	}
+	b.WriteString(")\nprint(resp.status_code, resp.reason)\nprint(resp.text)\n")
+	return b.String()
+}
+
+func fetchSnippet(r *snippetRequest) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "const resp = await fetch(%s, {\n  method: %s,\n", jsString(r.URL), jsString(r.Method))
+	if len(r.Headers) > 0 {
+		b.WriteString("  headers: {\n")
+		for _, h := range joinedHeaders(r.Headers) {
+			fmt.Fprintf(&b, "    %s: %s,\n", jsString(h[0]), jsString(h[1]))
+		}
+		b.WriteString("  },\n")
+	}
+	switch {
+	case r.Binary:
+		fmt.Fprintf(&b, "  body: Uint8Array.from(atob(%s), (c) => c.charCodeAt(0)),\n", jsString(r.Body))
+	case r.Body != "":
+		fmt.Fprintf(&b, "  body: %s,\n", jsString(r.Body))
+	}
+	b.WriteString("});\nconsole.log(resp.status, resp.statusText);\nconsole.log(await resp.text());\n")
+	return b.String()
+}
+
+// Quoting
+//
+
+// shellQuote single quotes for sh, closing and reopening the quotes around any ' in s.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// jsString is a JSON string, which is good JavaScript and Python too.
+func jsString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// joinedHeaders puts repeated headers together, for the formats that take a map.
+func joinedHeaders(hs [][2]string) [][2]string {
+	joined := [][2]string{}
+	for _, h := range hs {
+		if n := len(joined); n > 0 && joined[n-1][0] == h[0] {
+			joined[n-1][1] += ", " + h[1]
+			continue
+		}
+		joined = append(joined, h)
+	}
+	return joined
+}
@@ -0,0 +1,389 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	connection "github.com/jdrivas/conman"
+	t "github.com/jdrivas/termtext"
+	config "github.com/jdrivas/vconfig"
+	"github.com/juju/ansiterm"
+	"github.com/spf13/cobra"
+)
+
+/*
+Session Statistics
+
+The transport records a sample for every request it sends. Samples are grouped by
+connection and by "path template", the request path with the ids taken out,
+so /users/1234 and /users/5678 are both counted as /users/{id}.
+
+Only the last statsSize samples are kept, so a long bench or a proxy that's left
+running doesn't grow without end. The statistics are for those.
+*/
+
+var statsCmd *cobra.Command
+
+func buildStats(mode runMode) {
+
+	showCmd.AddCommand(&cobra.Command{
+		Use:   "stats [<connection-name>]",
+		Short: "Latency statistics for requests sent this session.",
+		Long: `Display request count, error rate, latency percentiles and throughput for the requests
+sent this session, for each connection and for each path on the connection.
+Errors are requests that failed to send or got a 4xx or 5xx status.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			name := ""
+			if len(args) > 0 {
+				name = args[0]
+			}
+			summaries := session.summarize(name)
+			printOutput(summaries, func() { displayStats(summaries) })
+		},
+	})
+
+	statsCmd = &cobra.Command{
+		Use:   "stats",
+		Short: "Manage session statistics.",
+		Long:  "Reset or export the request statistics collected this session.",
+	}
+	rootCmd.AddCommand(statsCmd)
+
+	statsCmd.AddCommand(&cobra.Command{
+		Use:   "reset",
+		Short: "Clear the session statistics.",
+		Long:  "Throw away all of the request samples collected so far.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			session.reset()
+			fmt.Printf("%s\n", t.Success("Statistics reset."))
+		},
+	})
+
+	statsCmd.AddCommand(&cobra.Command{
+		Use:     "export <file>",
+		Short:   "Write the session statistics in Prometheus text format.",
+		Long:    "Writes the statistics to a file in the Prometheus text exposition format, e.g. for the node exporter's textfile collector.",
+		Example: fmt.Sprintf("%s stats export /var/lib/node_exporter/%s.prom", config.AppName, config.AppName),
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := ioutil.WriteFile(args[0], []byte(prometheusText(session.summarize(""))), 0644); err != nil {
+				fmt.Printf("%s\n", t.Error(err))
+				return
+			}
+			fmt.Printf("%s %s\n", t.Title("Statistics written to"), t.Highlight("%s", args[0]))
+		},
+	})
+}
+
+// Samples
+//
+
+type requestSample struct {
+	Connection string
+	Method     string
+	Path       string // template
+	Status     int    // 0 if the request failed.
+	Bytes      int64
+	Start      time.Time
+	Phases     timingPhases
+	Failed     bool
+}
+
+// How many samples are kept.
+const statsSize = 100000
+
+// sessionStats keeps the samples in a ring, next is where the next one goes once it's full.
+type sessionStats struct {
+	mu      sync.Mutex
+	samples []requestSample
+	next    int
+}
+
+var session = &sessionStats{}
+
+// record adds a sample for a request the transport sent.
+func (ss *sessionStats) record(ex *exchange, req *http.Request, resp *http.Response, size int64, err error) {
+	s := requestSample{
+		Method: req.Method,
+		Path:   pathTemplate(req.URL.Path),
+		Bytes:  size,
+		Start:  ex.Timing.Start,
+		Phases: ex.Timing.phases(),
+		Failed: err != nil,
+	}
+	if ex.Connection != nil {
+		s.Connection = ex.Connection.Name
+		if base := strings.TrimSuffix(serviceURLPath(ex.Connection), "/"); base != "" {
+			s.Path = pathTemplate(strings.TrimPrefix(req.URL.Path, base))
+		}
+	} else {
+		s.Connection = req.URL.Host
+	}
+	if resp != nil {
+		s.Status = resp.StatusCode
+		s.Failed = s.Failed || resp.StatusCode >= 400
+	}
+
+	ss.mu.Lock()
+	if len(ss.samples) < statsSize {
+		ss.samples = append(ss.samples, s)
+	} else {
+		ss.samples[ss.next] = s
+		ss.next = (ss.next + 1) % statsSize
+	}
+	ss.mu.Unlock()
+}
+
+func (ss *sessionStats) reset() {
+	ss.mu.Lock()
+	ss.samples, ss.next = nil, 0
+	ss.mu.Unlock()
+}
+
+// Path templates
+//
+
+var (
+	uuidSegment = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	numSegment  = regexp.MustCompile(`^[0-9]+$`)
+	hexSegment  = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+)
+
+// pathTemplate replaces the id-like segments of the path.
+func pathTemplate(p string) string {
+	if p == "" {
+		return "/"
+	}
+	segs := strings.Split(p, "/")
+	for i, s := range segs {
+		switch {
+		case uuidSegment.MatchString(s):
+			segs[i] = "{uuid}"
+		case numSegment.MatchString(s):
+			segs[i] = "{id}"
+		case hexSegment.MatchString(s):
+			segs[i] = "{hex}"
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+// The path part of the connection's service URL, which we leave off of the path templates.
+func serviceURLPath(conn *connection.Connection) string {
+	if i := strings.Index(conn.ServiceURL, "://"); i >= 0 {
+		rest := conn.ServiceURL[i+3:]
+		if j := strings.Index(rest, "/"); j >= 0 {
+			return rest[j:]
+		}
+	}
+	return ""
+}
+
+// Summaries
+//
+
+type statsSummary struct {
+	Connection     string         `json:"connection"`
+	Path           string         `json:"path,omitempty"`
+	Count          int            `json:"count"`
+	Errors         int            `json:"errors"`
+	ErrorRate      float64        `json:"errorRate"`
+	P50            float64        `json:"p50Ms"`
+	P90            float64        `json:"p90Ms"`
+	P99            float64        `json:"p99Ms"`
+	SumSeconds     float64        `json:"sumSeconds"`
+	Bytes          int64          `json:"bytes"`
+	RequestsPerSec float64        `json:"requestsPerSecond"`
+	BytesPerSec    float64        `json:"bytesPerSecond"`
+	Statuses       map[int]int    `json:"statuses"`
+	Paths          []statsSummary `json:"paths,omitempty"`
+}
+
+// summarize returns a summary per connection, each with summaries per path.
+// An empty name summarizes all connections.
+func (ss *sessionStats) summarize(name string) (sums []statsSummary) {
+	ss.mu.Lock()
+	samples := make([]requestSample, len(ss.samples))
+	copy(samples, ss.samples)
+	ss.mu.Unlock()
+
+	byConn := map[string][]requestSample{}
+	for _, s := range samples {
+		if name == "" || s.Connection == name {
+			byConn[s.Connection] = append(byConn[s.Connection], s)
+		}
+	}
+	for cn, cs := range byConn {
+		sum := summarizeSamples(cs)
+		sum.Connection = cn
+
+		byPath := map[string][]requestSample{}
+		for _, s := range cs {
+			k := s.Method + " " + s.Path
+			byPath[k] = append(byPath[k], s)
+		}
+		for p, ps := range byPath {
+			psum := summarizeSamples(ps)
+			psum.Connection = cn
+			psum.Path = p
+			sum.Paths = append(sum.Paths, psum)
+		}
+		sort.Slice(sum.Paths, func(i, j int) bool { return sum.Paths[i].Path < sum.Paths[j].Path })
+		sums = append(sums, sum)
+	}
+	sort.Slice(sums, func(i, j int) bool { return sums[i].Connection < sums[j].Connection })
+	return sums
+}
+
+func summarizeSamples(samples []requestSample) (sum statsSummary) {
+	sum.Count = len(samples)
+	sum.Statuses = map[int]int{}
+	var first, last time.Time
+	var durations []time.Duration
+	for _, s := range samples {
+		if s.Failed {
+			sum.Errors++
+		}
+		sum.Statuses[s.Status]++
+		sum.Bytes += s.Bytes
+		durations = append(durations, s.Phases.Total)
+		sum.SumSeconds += s.Phases.Total.Seconds()
+		if first.IsZero() || s.Start.Before(first) {
+			first = s.Start
+		}
+		if end := s.Start.Add(s.Phases.Total); end.After(last) {
+			last = end
+		}
+	}
+	if sum.Count > 0 {
+		sum.ErrorRate = float64(sum.Errors) / float64(sum.Count)
+	}
+	sum.P50 = ms(percentile(durations, 50))
+	sum.P90 = ms(percentile(durations, 90))
+	sum.P99 = ms(percentile(durations, 99))
+	if window := last.Sub(first).Seconds(); window > 0 {
+		sum.RequestsPerSec = float64(sum.Count) / window
+		sum.BytesPerSec = float64(sum.Bytes) / window
+	}
+	return sum
+}
+
+// percentile uses the nearest rank method.
+func percentile(ds []time.Duration, p float64) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(ds))
+	copy(sorted, ds)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// Display
+//
+
+func displayStats(sums []statsSummary) {
+	if len(sums) == 0 {
+		fmt.Printf("%s\n", t.Title("No requests have been sent."))
+		return
+	}
+	w := ansiterm.NewTabWriter(os.Stdout, 4, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\n", t.Title("Connection\tPath\tCount\tErrors\tp50\tp90\tp99\tReq/s\tKB/s"))
+	for _, s := range sums {
+		fmt.Fprintf(w, "%s\t%s\n", t.Highlight("%s", s.Connection), statsRow(s, "*"))
+		for _, p := range s.Paths {
+			fmt.Fprintf(w, "\t%s\n", statsRow(p, p.Path))
+		}
+	}
+	w.Flush()
+}
+
+func statsRow(s statsSummary, path string) string {
+	errors := t.Text("%.1f%%", 100*s.ErrorRate)
+	if s.Errors > 0 {
+		errors = t.Fail("%.1f%%", 100*s.ErrorRate)
+	}
+	return fmt.Sprintf("%s\t%s\t%s\t%s", t.Text("%s\t%d", path, s.Count), errors,
+		t.Text("%.1fms\t%.1fms\t%.1fms", s.P50, s.P90, s.P99),
+		t.Text("%.2f\t%.2f", s.RequestsPerSec, s.BytesPerSec/1024))
+}
+
+// Prometheus
+//
+
+func prometheusText(sums []statsSummary) string {
+	var b strings.Builder
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	prefix := config.AppName
+
+	metric(prefix+"_requests_total", "counter", "Requests sent, by connection, path and status.")
+	for _, s := range sums {
+		for _, p := range s.Paths {
+			statuses := []int{}
+			for st := range p.Statuses {
+				statuses = append(statuses, st)
+			}
+			sort.Ints(statuses)
+			for _, st := range statuses {
+				fmt.Fprintf(&b, "%s_requests_total{%s,status=\"%d\"} %d\n", prefix, promLabels(p), st, p.Statuses[st])
+			}
+		}
+	}
+
+	metric(prefix+"_request_errors_total", "counter", "Requests that failed or got a 4xx or 5xx status.")
+	for _, s := range sums {
+		for _, p := range s.Paths {
+			fmt.Fprintf(&b, "%s_request_errors_total{%s} %d\n", prefix, promLabels(p), p.Errors)
+		}
+	}
+
+	metric(prefix+"_response_bytes_total", "counter", "Response body bytes received.")
+	for _, s := range sums {
+		for _, p := range s.Paths {
+			fmt.Fprintf(&b, "%s_response_bytes_total{%s} %d\n", prefix, promLabels(p), p.Bytes)
+		}
+	}
+
+	metric(prefix+"_request_duration_seconds", "summary", "Request latency.")
+	for _, s := range sums {
+		for _, p := range s.Paths {
+			l := promLabels(p)
+			for _, q := range []struct {
+				q string
+				v float64
+			}{{"0.5", p.P50}, {"0.9", p.P90}, {"0.99", p.P99}} {
+				fmt.Fprintf(&b, "%s_request_duration_seconds{%s,quantile=\"%s\"} %g\n", prefix, l, q.q, q.v/1000)
+			}
+			fmt.Fprintf(&b, "%s_request_duration_seconds_sum{%s} %g\n", prefix, l, p.SumSeconds)
+			fmt.Fprintf(&b, "%s_request_duration_seconds_count{%s} %d\n", prefix, l, p.Count)
+		}
+	}
+	return b.String()
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promLabels(s statsSummary) string {
+	method, path := s.Path, ""
+	if i := strings.Index(s.Path, " "); i >= 0 {
+		method, path = s.Path[:i], s.Path[i+1:]
+	}
+	return fmt.Sprintf(`connection="%s",method="%s",path="%s"`,
+		promEscaper.Replace(s.Connection), promEscaper.Replace(method), promEscaper.Replace(path))
+}
@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	t "github.com/jdrivas/termtext"
+	"github.com/juju/ansiterm"
+)
+
+// requestTiming collects the httptrace events for a request.
+// Callbacks can come from different goroutines (e.g. parallel dials),
+// hence the lock.
+type requestTiming struct {
+	mu sync.Mutex
+
+	Start        time.Time
+	DNSStart     time.Time
+	DNSDone      time.Time
+	ConnectStart time.Time
+	ConnectDone  time.Time
+	TLSStart     time.Time
+	TLSDone      time.Time
+	GotConn      time.Time
+	WroteRequest time.Time
+	FirstByte    time.Time
+	Done         time.Time
+	Reused       bool
+}
+
+// timingPhases is the waterfall, worked out from the events.
+type timingPhases struct {
+	DNS, Connect, TLS, Server, Transfer time.Duration
+	TTFB, Total                         time.Duration
+	Reused                              bool
+}
+
+// trace returns a ClientTrace that records into rt.
+func (rt *requestTiming) trace() *httptrace.ClientTrace {
+	mark := func(f func()) {
+		rt.mu.Lock()
+		f()
+		rt.mu.Unlock()
+	}
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { mark(func() { rt.DNSStart = time.Now() }) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { mark(func() { rt.DNSDone = time.Now() }) },
+		ConnectStart: func(string, string) {
+			mark(func() {
+				if rt.ConnectStart.IsZero() {
+					rt.ConnectStart = time.Now()
+				}
+			})
+		},
+		ConnectDone:       func(string, string, error) { mark(func() { rt.ConnectDone = time.Now() }) },
+		TLSHandshakeStart: func() { mark(func() { rt.TLSStart = time.Now() }) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { mark(func() { rt.TLSDone = time.Now() }) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			mark(func() {
+				rt.GotConn = time.Now()
+				rt.Reused = info.Reused
+			})
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { mark(func() { rt.WroteRequest = time.Now() }) },
+		GotFirstResponseByte: func() { mark(func() { rt.FirstByte = time.Now() }) },
+	}
+}
+
+func (rt *requestTiming) done() {
+	rt.mu.Lock()
+	rt.Done = time.Now()
+	rt.mu.Unlock()
+}
+
+func (rt *requestTiming) phases() (p timingPhases) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	span := func(start, end time.Time) time.Duration {
+		if start.IsZero() || end.IsZero() || end.Before(start) {
+			return 0
+		}
+		return end.Sub(start)
+	}
+	p = timingPhases{
+		DNS:      span(rt.DNSStart, rt.DNSDone),
+		Connect:  span(rt.ConnectStart, rt.ConnectDone),
+		TLS:      span(rt.TLSStart, rt.TLSDone),
+		Server:   span(rt.WroteRequest, rt.FirstByte),
+		Transfer: span(rt.FirstByte, rt.Done),
+		TTFB:     span(rt.Start, rt.FirstByte),
+		Total:    span(rt.Start, rt.Done),
+		Reused:   rt.Reused,
+	}
+	return p
+}
+
+// Display
+//
+
+const waterfallWidth = 40
+
+// displayTiming prints the phases as a waterfall.
+func displayTiming(p timingPhases) {
+	type row struct {
+		name       string
+		start, len time.Duration
+	}
+	var at time.Duration
+	rows := []row{}
+	for _, r := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"DNS Lookup", p.DNS},
+		{"TCP Connect", p.Connect},
+		{"TLS Handshake", p.TLS},
+		{"Server Processing", p.Server},
+		{"Content Transfer", p.Transfer},
+	} {
+		rows = append(rows, row{r.name, at, r.d})
+		at += r.d
+	}
+
+	scale := func(d time.Duration) int {
+		if p.Total <= 0 {
+			return 0
+		}
+		return int(float64(waterfallWidth) * float64(d) / float64(p.Total))
+	}
+
+	reused := "new connection"
+	if p.Reused {
+		reused = "reused connection"
+	}
+	w := ansiterm.NewTabWriter(os.Stdout, 4, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\n", t.Title("Phase\tTime\tWaterfall (%s)", reused))
+	for _, r := range rows {
+		bar := strings.Repeat(" ", scale(r.start))
+		if r.len > 0 {
+			bar += strings.Repeat("=", maxInt(scale(r.len), 1))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", t.Title(r.name), t.Text("%s", durationMs(r.len)), t.Info("|%-*s|", waterfallWidth, bar))
+	}
+	fmt.Fprintf(w, "%s\t%s\n", t.Title("Time to First Byte"), t.Text("%s", durationMs(p.TTFB)))
+	fmt.Fprintf(w, "%s\t%s\n", t.Title("Total"), t.Text("%s", durationMs(p.Total)))
+	w.Flush()
+}
+
+// timingJSON is the JSON form of the phases, in milliseconds.
+type timingJSON struct {
+	DNSLookup        float64 `json:"dnsLookupMs"`
+	TCPConnect       float64 `json:"tcpConnectMs"`
+	TLSHandshake     float64 `json:"tlsHandshakeMs"`
+	ServerProcessing float64 `json:"serverProcessingMs"`
+	ContentTransfer  float64 `json:"contentTransferMs"`
+	TimeToFirstByte  float64 `json:"timeToFirstByteMs"`
+	Total            float64 `json:"totalMs"`
+	ConnectionReused bool    `json:"connectionReused"`
+}
+
+func (p timingPhases) json() timingJSON {
+	return timingJSON{
+		DNSLookup:        ms(p.DNS),
+		TCPConnect:       ms(p.Connect),
+		TLSHandshake:     ms(p.TLS),
+		ServerProcessing: ms(p.Server),
+		ContentTransfer:  ms(p.Transfer),
+		TimeToFirstByte:  ms(p.TTFB),
+		Total:            ms(p.Total),
+		ConnectionReused: p.Reused,
+	}
+}
+
+// timedResponse is the response body along with the timing, for the non-table formats.
+type timedResponse struct {
+	Status int         `json:"status"`
+	Timing timingJSON  `json:"timing"`
+	Body   interface{} `json:"body"`
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func durationMs(d time.Duration) string {
+	return fmt.Sprintf("%.2fms", ms(d))
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
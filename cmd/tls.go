@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	connection "github.com/jdrivas/conman"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/pkcs12"
+)
+
+/*
+Connection TLS
+
+Each connection can carry its own TLS settings:
+
+connections:
+      internal:
+            serviceURL: https://internal.example.com
+            tls:
+                  cert: ~/certs/client.pem       # PEM client certificate and ...
+                  key: ~/certs/client-key.pem    # ... its key, or
+                  pkcs12: ~/certs/client.p12     # a PKCS#12 bundle with both
+                  pkcs12Password: secret
+                  ca: ~/certs/internal-ca.pem    # CA bundle to trust, instead of the system roots
+                  serverName: internal.example.com
+                  minVersion: "1.2"              # 1.0, 1.1, 1.2, 1.3
+                  insecure: false                # skip server verification (you'll be warned)
+
+The top level tls values override those of the connection. That's where the
+--tls-* and --insecure flags are bound, so flags take precedence the same way
+they do for the rest of the configuration.
+*/
+
+// TLS configuration keys, relative to the connection (or top level for overrides).
+const (
+	tlsKey               = "tls"            // map[string]interface{}
+	tlsCertKey           = "cert"           // string
+	tlsKeyKey            = "key"            // string
+	tlsPKCS12Key         = "pkcs12"         // string
+	tlsPKCS12PasswordKey = "pkcs12Password" // string
+	tlsCAKey             = "ca"             // string
+	tlsServerNameKey     = "serverName"     // string
+	tlsMinVersionKey     = "minVersion"     // string
+	tlsInsecureKey       = "insecure"       // bool
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsSettings are the resolved TLS values for a connection.
+type tlsSettings struct {
+	Cert, Key              string
+	PKCS12, PKCS12Password string
+	CA                     string
+	ServerName             string
+	MinVersion             string
+	Insecure               bool
+}
+
+// getTLSSettings resolves the TLS settings for the connection.
+// conn may be nil, in which case you just get the overrides.
+func getTLSSettings(conn *connection.Connection) (ts tlsSettings) {
+	s := func(k string) string {
+		if v := viper.GetString(tlsKey + "." + k); v != "" {
+			return v
+		}
+		if conn != nil {
+			return viper.GetString(connectionKey(conn.Name, tlsKey, k))
+		}
+		return ""
+	}
+	ts = tlsSettings{
+		Cert:           s(tlsCertKey),
+		Key:            s(tlsKeyKey),
+		PKCS12:         s(tlsPKCS12Key),
+		PKCS12Password: s(tlsPKCS12PasswordKey),
+		CA:             s(tlsCAKey),
+		ServerName:     s(tlsServerNameKey),
+		MinVersion:     s(tlsMinVersionKey),
+	}
+	// The override can turn insecure on, but not off.
+	ts.Insecure = viper.GetBool(tlsKey + "." + tlsInsecureKey)
+	if !ts.Insecure && conn != nil {
+		ts.Insecure = viper.GetBool(connectionKey(conn.Name, tlsKey, tlsInsecureKey))
+	}
+	return ts
+}
+
+// isInsecure reports whether the connection skips server verification.
+func isInsecure(conn *connection.Connection) bool {
+	return getTLSSettings(conn).Insecure
+}
+
+// tlsConfig builds a tls.Config from the settings.
+// Returns nil if there is nothing to configure.
+func (ts tlsSettings) tlsConfig() (*tls.Config, error) {
+	if ts == (tlsSettings{}) {
+		return nil, nil
+	}
+
+	tc := &tls.Config{
+		ServerName:         ts.ServerName,
+		InsecureSkipVerify: ts.Insecure,
+	}
+
+	if ts.MinVersion != "" {
+		v, ok := tlsVersions[ts.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS minimum version %q (use 1.0, 1.1, 1.2 or 1.3)", ts.MinVersion)
+		}
+		tc.MinVersion = v
+	}
+
+	if ts.CA != "" {
+		b, err := ioutil.ReadFile(expandHome(ts.CA))
+		if err != nil {
+			return nil, err
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", ts.CA)
+		}
+	}
+
+	switch {
+	case ts.PKCS12 != "":
+		cert, err := loadPKCS12(expandHome(ts.PKCS12), ts.PKCS12Password)
+		if err != nil {
+			return nil, err
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	case ts.Cert != "" || ts.Key != "":
+		if ts.Cert == "" || ts.Key == "" {
+			return nil, errors.New("a client certificate needs both a cert and a key")
+		}
+		cert, err := tls.LoadX509KeyPair(expandHome(ts.Cert), expandHome(ts.Key))
+		if err != nil {
+			return nil, err
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	return tc, nil
+}
+
+func loadPKCS12(fn, password string) (cert tls.Certificate, err error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return cert, err
+	}
+	blocks, err := pkcs12.ToPEM(b, password)
+	if err != nil {
+		return cert, fmt.Errorf("couldn't read PKCS#12 %s: %v", fn, err)
+	}
+	var certPEM, keyPEM []byte
+	for _, b := range blocks {
+		if strings.Contains(b.Type, "PRIVATE KEY") {
+			keyPEM = append(keyPEM, pem.EncodeToMemory(b)...)
+		} else {
+			certPEM = append(certPEM, pem.EncodeToMemory(b)...)
+		}
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// Transports
+// Connections with the same TLS settings share an http.Transport,
+// so they share the connection pool too.
+//
+
+var (
+	tlsTransports   = map[tlsSettings]*http.Transport{}
+	tlsTransportsMu sync.Mutex
+)
+
+// transportFor returns the round tripper to use for the connection.
+func transportFor(conn *connection.Connection, base http.RoundTripper) (http.RoundTripper, error) {
+	ts := getTLSSettings(conn)
+	if ts == (tlsSettings{}) {
+		return base, nil
+	}
+
+	tlsTransportsMu.Lock()
+	defer tlsTransportsMu.Unlock()
+	if tr, ok := tlsTransports[ts]; ok {
+		return tr, nil
+	}
+
+	tc, err := ts.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = tc
+	tlsTransports[ts] = tr
+	return tr, nil
+}
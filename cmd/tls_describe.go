@@ -0,0 +1,308 @@
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	connection "github.com/jdrivas/conman"
+	t "github.com/jdrivas/termtext"
+	config "github.com/jdrivas/vconfig"
+	"github.com/juju/ansiterm"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ocsp"
+)
+
+const tlsDialTimeout = 10 * time.Second
+
+// Warn when a certificate is this close to expiring.
+const certExpiryWarning = 30 * 24 * time.Hour
+
+func buildTLS(mode runMode) {
+
+	describeCmd.AddCommand(&cobra.Command{
+		Use:   "tls [flags] [<connection-name>]",
+		Short: "Details about a connection's TLS handshake and certificates.",
+		Long: `Performs a TLS handshake with the connection's host (the current connection by default) and
+displays the negotiated protocol, cipher suite and ALPN, the certificate chain,
+OCSP stapling status, and any errors verifying the chain.
+
+Use -o json for output suitable for scripting certificate expiry checks.`,
+		Example: fmt.Sprintf("%s describe tls -o json production", config.AppName),
+		Args:    cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var conn *connection.Connection
+			var err error
+			if len(args) > 0 {
+				var ok bool
+				if conn, ok = connection.GetConnection(args[0]); !ok {
+					err = fmt.Errorf("couldn't find a connection for %s", args[0])
+				}
+			} else {
+				conn, err = connection.GetCurrentConnection()
+			}
+			if err == nil {
+				var r *tlsReport
+				if r, err = inspectTLS(conn); err == nil {
+					printOutput(r, r.Describe)
+				}
+			}
+			if err != nil {
+				fmt.Printf("%s\n", t.Error(err))
+			}
+		},
+	})
+}
+
+// tlsReport is what we learned from the handshake.
+type tlsReport struct {
+	Connection  string      `json:"connection"`
+	Address     string      `json:"address"`
+	ServerName  string      `json:"serverName"`
+	Version     string      `json:"version"`
+	CipherSuite string      `json:"cipherSuite"`
+	ALPN        string      `json:"alpn"`
+	OCSP        string      `json:"ocsp"`
+	Verified    bool        `json:"verified"`
+	VerifyError string      `json:"verifyError,omitempty"`
+	Chain       []certEntry `json:"chain"`
+}
+
+type certEntry struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	SANs               []string  `json:"sans,omitempty"`
+	Serial             string    `json:"serial"`
+	NotBefore          time.Time `json:"notBefore"`
+	NotAfter           time.Time `json:"notAfter"`
+	DaysRemaining      int       `json:"daysRemaining"`
+	KeyType            string    `json:"keyType"`
+	SignatureAlgorithm string    `json:"signatureAlgorithm"`
+	IsCA               bool      `json:"isCA"`
+}
+
+var tlsVersionNames = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// tls.CipherSuiteName is Go 1.14, we're 1.13. These are the suites Go knows.
+var cipherSuiteNames = map[uint16]string{
+	tls.TLS_RSA_WITH_RC4_128_SHA:                "TLS_RSA_WITH_RC4_128_SHA",
+	tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA:           "TLS_RSA_WITH_3DES_EDE_CBC_SHA",
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA:            "TLS_RSA_WITH_AES_128_CBC_SHA",
+	tls.TLS_RSA_WITH_AES_256_CBC_SHA:            "TLS_RSA_WITH_AES_256_CBC_SHA",
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA256:         "TLS_RSA_WITH_AES_128_CBC_SHA256",
+	tls.TLS_RSA_WITH_AES_128_GCM_SHA256:         "TLS_RSA_WITH_AES_128_GCM_SHA256",
+	tls.TLS_RSA_WITH_AES_256_GCM_SHA384:         "TLS_RSA_WITH_AES_256_GCM_SHA384",
+	tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA:        "TLS_ECDHE_ECDSA_WITH_RC4_128_SHA",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA:    "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA:    "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA",
+	tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA:          "TLS_ECDHE_RSA_WITH_RC4_128_SHA",
+	tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA:     "TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA",
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA:      "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA",
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA:      "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256",
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256:   "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256",
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256:   "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384:   "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384: "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305:    "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305",
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305:  "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305",
+	tls.TLS_AES_128_GCM_SHA256:                  "TLS_AES_128_GCM_SHA256",
+	tls.TLS_AES_256_GCM_SHA384:                  "TLS_AES_256_GCM_SHA384",
+	tls.TLS_CHACHA20_POLY1305_SHA256:            "TLS_CHACHA20_POLY1305_SHA256",
+}
+
+func cipherSuiteName(id uint16) string {
+	if n, ok := cipherSuiteNames[id]; ok {
+		return n
+	}
+	return fmt.Sprintf("0x%04X", id)
+}
+
+// inspectTLS handshakes with the connection's host.
+// We don't let the handshake fail on verification, so we
+// can report on what's wrong with the chain. Verification is done after.
+func inspectTLS(conn *connection.Connection) (r *tlsReport, err error) {
+	u, err := url.Parse(conn.ServiceURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("connection %q isn't using https: %s", conn.Name, conn.ServiceURL)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "443")
+	}
+
+	ts := getTLSSettings(conn)
+	tc, err := ts.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tc == nil {
+		tc = &tls.Config{}
+	}
+	tc = tc.Clone()
+	if tc.ServerName == "" {
+		tc.ServerName = u.Hostname()
+	}
+	tc.InsecureSkipVerify = true
+	tc.NextProtos = []string{"h2", "http/1.1"}
+
+	tconn, err := tls.DialWithDialer(&net.Dialer{Timeout: tlsDialTimeout}, "tcp", addr, tc)
+	if err != nil {
+		return nil, err
+	}
+	defer tconn.Close()
+	state := tconn.ConnectionState()
+
+	r = &tlsReport{
+		Connection:  conn.Name,
+		Address:     addr,
+		ServerName:  tc.ServerName,
+		Version:     tlsVersionNames[state.Version],
+		CipherSuite: cipherSuiteName(state.CipherSuite),
+		ALPN:        state.NegotiatedProtocol,
+	}
+
+	now := time.Now()
+	for _, c := range state.PeerCertificates {
+		r.Chain = append(r.Chain, newCertEntry(c, now))
+	}
+
+	// Verify the chain the way the handshake would have.
+	if len(state.PeerCertificates) > 0 {
+		opts := x509.VerifyOptions{
+			Roots:         tc.RootCAs,
+			DNSName:       tc.ServerName,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, c := range state.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(c)
+		}
+		if _, err := state.PeerCertificates[0].Verify(opts); err != nil {
+			r.VerifyError = err.Error()
+		} else {
+			r.Verified = true
+		}
+	}
+
+	r.OCSP = ocspStatus(state)
+	return r, nil
+}
+
+func ocspStatus(state tls.ConnectionState) string {
+	if len(state.OCSPResponse) == 0 {
+		return "not stapled"
+	}
+	var issuer *x509.Certificate
+	if len(state.PeerCertificates) > 1 {
+		issuer = state.PeerCertificates[1]
+	}
+	resp, err := ocsp.ParseResponse(state.OCSPResponse, issuer)
+	if err != nil {
+		return fmt.Sprintf("stapled, unreadable: %v", err)
+	}
+	switch resp.Status {
+	case ocsp.Good:
+		return fmt.Sprintf("stapled, good (next update %s)", resp.NextUpdate.Local().Format(time.RFC1123))
+	case ocsp.Revoked:
+		return fmt.Sprintf("stapled, REVOKED at %s", resp.RevokedAt.Local().Format(time.RFC1123))
+	}
+	return "stapled, unknown"
+}
+
+func newCertEntry(c *x509.Certificate, now time.Time) certEntry {
+	ce := certEntry{
+		Subject:            c.Subject.String(),
+		Issuer:             c.Issuer.String(),
+		Serial:             c.SerialNumber.String(),
+		NotBefore:          c.NotBefore,
+		NotAfter:           c.NotAfter,
+		DaysRemaining:      int(c.NotAfter.Sub(now).Hours() / 24),
+		KeyType:            publicKeyType(c.PublicKey),
+		SignatureAlgorithm: c.SignatureAlgorithm.String(),
+		IsCA:               c.IsCA,
+	}
+	ce.SANs = append(ce.SANs, c.DNSNames...)
+	for _, ip := range c.IPAddresses {
+		ce.SANs = append(ce.SANs, ip.String())
+	}
+	ce.SANs = append(ce.SANs, c.EmailAddresses...)
+	for _, u := range c.URIs {
+		ce.SANs = append(ce.SANs, u.String())
+	}
+	return ce
+}
+
+func publicKeyType(k interface{}) string {
+	switch pk := k.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", pk.N.BitLen())
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("ECDSA %s", pk.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return fmt.Sprintf("%T", k)
+}
+
+// Display
+//
+
+func (r *tlsReport) Describe() {
+	w := ansiterm.NewTabWriter(os.Stdout, 4, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\t%s\n", t.Title("Connection:"), t.Text("%s (%s)", r.Connection, r.Address))
+	fmt.Fprintf(w, "%s\t%s\n", t.Title("Server Name:"), t.Text("%s", r.ServerName))
+	fmt.Fprintf(w, "%s\t%s\n", t.Title("Protocol:"), t.Text("%s", r.Version))
+	fmt.Fprintf(w, "%s\t%s\n", t.Title("Cipher Suite:"), t.Text("%s", r.CipherSuite))
+	fmt.Fprintf(w, "%s\t%s\n", t.Title("ALPN:"), t.Text("%s", r.ALPN))
+	fmt.Fprintf(w, "%s\t%s\n", t.Title("OCSP:"), t.Text("%s", r.OCSP))
+	if r.Verified {
+		fmt.Fprintf(w, "%s\t%s\n", t.Title("Chain:"), t.Success("verified"))
+	} else {
+		fmt.Fprintf(w, "%s\t%s\n", t.Title("Chain:"), t.Fail("%s", r.VerifyError))
+	}
+	w.Flush()
+
+	for i, c := range r.Chain {
+		fmt.Println()
+		w = ansiterm.NewTabWriter(os.Stdout, 4, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "%s\n", t.Title("Certificate %d\t", i))
+		fmt.Fprintf(w, "%s\t%s\n", t.Title("  Subject:"), t.Text("%s", c.Subject))
+		fmt.Fprintf(w, "%s\t%s\n", t.Title("  Issuer:"), t.Text("%s", c.Issuer))
+		if len(c.SANs) > 0 {
+			fmt.Fprintf(w, "%s\t%s\n", t.Title("  SANs:"), t.Text("%s", strings.Join(c.SANs, ", ")))
+		}
+		fmt.Fprintf(w, "%s\t%s\n", t.Title("  Key:"), t.Text("%s (%s)", c.KeyType, c.SignatureAlgorithm))
+		fmt.Fprintf(w, "%s\t%s\n", t.Title("  Valid From:"), t.Text("%s", c.NotBefore.Local().Format(time.RFC1123)))
+		fmt.Fprintf(w, "%s\t%s\n", t.Title("  Valid Until:"), certExpiryText(c))
+		w.Flush()
+	}
+}
+
+func certExpiryText(c certEntry) string {
+	until := c.NotAfter.Local().Format(time.RFC1123)
+	remaining := time.Until(c.NotAfter)
+	switch {
+	case remaining <= 0:
+		return t.Fail("%s (expired)", until)
+	case remaining < certExpiryWarning:
+		return t.Warn("%s (%d days)", until, c.DaysRemaining)
+	}
+	return t.Text("%s (%d days)", until, c.DaysRemaining)
+}
@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	connection "github.com/jdrivas/conman"
+	t "github.com/jdrivas/termtext"
+	config "github.com/jdrivas/vconfig"
+	"github.com/spf13/viper"
+)
+
+/*
+Request Transport
+
+conman sends every request through http.DefaultClient and doesn't give us
+a hook into request construction. So, to add behavior to the requests the
+http commands build (e.g. signing), we install our own http.RoundTripper on
+the default client and do the work there, just before the request goes out
+on the wire.
+
+The transport doesn't keep any state of its own. Each request looks up the
+connection it is going to (by service URL) and the viper configuration for
+that connection, so it follows connection changes, flags and sets like
+everything else.
+*/
+
+// errDryRun is returned by the transport in place of sending a request
+// when the --dry-run flag is set.
+var errDryRun = errors.New("dry run: request not sent")
+
+type gafwTransport struct {
+	base http.RoundTripper
+}
+
+// exchange collects what the transport learned about a request and its response.
+// It travels on the request context, so it can be found from resp.Request
+// after conman hands the response back.
+type exchange struct {
+	Connection *connection.Connection
+	Signatures []signatureCheck // Response signature verification.
+	Timing     *requestTiming
+
+	requestBody []byte // For the history.
+}
+
+type exchangeKey struct{}
+
+// responseExchange returns the exchange for a response that came through the transport, or nil.
+func responseExchange(resp *http.Response) *exchange {
+	if resp == nil || resp.Request == nil {
+		return nil
+	}
+	ex, _ := resp.Request.Context().Value(exchangeKey{}).(*exchange)
+	return ex
+}
+
+// Install the transport on the client conman uses.
+// This is a package init function (see packageFuncs), so it gets
+// called regularly. It only needs to install once.
+func initTransport() {
+	if _, ok := http.DefaultClient.Transport.(*gafwTransport); !ok {
+		http.DefaultClient.Transport = &gafwTransport{base: http.DefaultTransport}
+	}
+}
+
+// RoundTrip signs the request as the connection's auth mode requires and sends it along.
+func (gt *gafwTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	// RoundTrippers shouldn't modify the request they're handed.
+	ex := &exchange{Connection: requestConnection(req), Timing: &requestTiming{}}
+	ctx := context.WithValue(req.Context(), exchangeKey{}, ex)
+	req = req.Clone(httptrace.WithClientTrace(ctx, ex.Timing.trace()))
+
+	if err := fillRequest(req); err != nil { // Before signing, the signature covers the values.
+		return nil, err
+	}
+
+	record, replay := viper.GetString(cassetteRecordKey), viper.GetString(cassetteReplayKey)
+	if record != "" && replay != "" {
+		return nil, errors.New("use --record or --replay, not both")
+	}
+	reqBody, err := requestBody(req) // For the history and cassettes.
+	if err != nil {
+		return nil, err
+	}
+	ex.requestBody = reqBody
+	if replay != "" && !dryRunFlag { // Before signing, a replay shouldn't need credentials.
+		return gt.replay(ex, req, reqBody, replay)
+	}
+
+	details, err := authorizeRequest(ex.Connection, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRunFlag {
+		printDryRun(req, details)
+		return nil, errDryRun
+	}
+	if config.Debug() && details != nil {
+		details.Describe()
+	}
+
+	var recording *cassette
+	if record != "" { // Before sending, a bad cassette shouldn't cost a request.
+		if recording, err = loadCassette(record, false); err != nil {
+			return nil, err
+		}
+	}
+
+	rt, err := transportFor(ex.Connection, gt.base)
+	if err != nil {
+		return nil, err
+	}
+
+	ex.Timing.Start = time.Now()
+	resp, err := rt.RoundTrip(req)
+	var body []byte
+	if err == nil {
+		// Read the body here so the content transfer gets timed.
+		var berr error
+		body, berr = responseBody(resp)
+		ex.Timing.done()
+		ex.Signatures = verifyResponseSignatures(ex.Connection, resp, body, berr)
+		noteResponseJWT(resp, body)
+	} else {
+		ex.Timing.done()
+	}
+	session.record(ex, req, resp, int64(len(body)), err)
+
+	if recording != nil && err == nil {
+		recording.add(newInteraction(ex, req, reqBody, resp, body))
+	}
+	return resp, err
+}
+
+// replay answers the request from the cassette instead of the network.
+func (gt *gafwTransport) replay(ex *exchange, req *http.Request, reqBody []byte, path string) (*http.Response, error) {
+	c, err := loadCassette(path, true)
+	if err != nil {
+		return nil, err
+	}
+	ex.Timing.Start = time.Now()
+	resp, err := c.replay(req, reqBody)
+	ex.Timing.done()
+	var body []byte
+	if err == nil {
+		body, _ = responseBody(resp)
+		ex.Signatures = verifyResponseSignatures(ex.Connection, resp, body, nil)
+		noteResponseJWT(resp, body)
+	}
+	session.record(ex, req, resp, int64(len(body)), err)
+	return resp, err
+}
+
+type requestConnectionKey struct{}
+
+// withConnection makes the request the connection's, whatever its URL.
+func withConnection(req *http.Request, conn *connection.Connection) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), requestConnectionKey{}, conn))
+}
+
+// requestConnection finds the connection the request is going to.
+// One set with withConnection wins. Then prefer the current connection,
+// otherwise take any connection with a matching service URL.
+// Returns nil if there isn't one.
+func requestConnection(req *http.Request) *connection.Connection {
+	if conn, ok := req.Context().Value(requestConnectionKey{}).(*connection.Connection); ok {
+		return conn
+	}
+	if conn, err := connection.GetCurrentConnection(); err == nil && servesURL(conn, req.URL) {
+		return conn
+	}
+	for _, c := range connection.GetAllConnections() {
+		if servesURL(c, req.URL) {
+			return c
+		}
+	}
+	return nil
+}
+
+// servesURL is true when u is under the connection's service URL: the same scheme,
+// host and port, and a path under its path. Not a string prefix, or
+// https://api.example.com would get the credentials for https://api.example.com.evil.io.
+func servesURL(conn *connection.Connection, u *url.URL) bool {
+	su, err := url.Parse(conn.ServiceURL)
+	if err != nil || su.Host == "" || !strings.EqualFold(su.Scheme, u.Scheme) || canonicalHost(su) != canonicalHost(u) {
+		return false
+	}
+	p := strings.TrimSuffix(su.Path, "/")
+	return p == "" || u.Path == p || strings.HasPrefix(u.Path, p+"/")
+}
+
+// requestBody returns a copy of the request body, leaving the
+// request with a body that can still be sent.
+func requestBody(req *http.Request) (body []byte, err error) {
+	switch {
+	case req.Body == nil || req.Body == http.NoBody:
+		return nil, nil
+	case req.GetBody != nil:
+		rc, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	default:
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+		return body, nil
+	}
+}
+
+// responseBody returns a copy of the response body, leaving
+// the body in place for whoever reads the response next.
+func responseBody(resp *http.Response) (body []byte, err error) {
+	if resp.Body == nil || resp.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, err
+}
+
+// Show what would have been sent.
+func printDryRun(req *http.Request, details signingDetails) {
+	fmt.Printf("%s %s\n", t.Title("Request:"), t.Text("%s %s", req.Method, req.URL))
+	keys := []string{}
+	for k := range req.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("  %s %s\n", t.Title("%s:", k), t.Text("%s", strings.Join(req.Header[k], ", ")))
+	}
+	if details != nil {
+		details.Describe()
+	}
+}
@@ -0,0 +1,304 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	t "github.com/jdrivas/termtext"
+	config "github.com/jdrivas/vconfig"
+	"github.com/juju/ansiterm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+/*
+Variables
+
+${name} in a request's path, query values, headers or body is filled in just before
+the request is signed and sent (see gafwTransport), so it works the same for every
+command that sends requests:
+
+	gafw set var userId 42
+	gafw http get /users/${userId}
+	gafw http post /orders '{"id": "${uuid}", "at": "${now}"}' --var userId=7
+
+Variables live in viper, under vars, so they come from the usual places:
+
+	vars:                         in the config file
+	      userId: 42
+	--var userId=42               on the app command line, for the session
+	set var userId 42             from then on
+	--var userId=7                on an interactive command line, for just that command
+
+with each one overriding the one above. Viper keeps its keys in lower case, so
+names aren't case sensitive. A variable set to nothing is the same as no variable.
+
+Each --var is bound to its variable like the other flags are bound to theirs (see
+init.go), with a flag of its own, so reset() puts back what an interactive --var changed.
+
+There are some generators too, a new value each time:
+
+	${uuid}              a random UUID
+	${now}               the time, RFC 3339 in UTC, or ${now unix} for Unix seconds
+	${randInt 1 100}     a random int from 1 up to (not including) 100
+
+A ${name} that isn't a variable or a generator is sent as it is.
+*/
+
+const varsKey = "vars"
+
+var varRE = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_.-]*)((?:\s+[^\s{}]+)*)\s*\}`)
+
+func buildVars(mode runMode) {
+	setCmd.AddCommand(&cobra.Command{
+		Use:     "var <name> <value>",
+		Aliases: []string{"variable"},
+		Short:   "Set a variable for ${name} in requests.",
+		Long:    "Set a variable to fill in ${name} in request paths, query values, headers and bodies.",
+		Example: fmt.Sprintf("  %s set var userId 42", config.AppName),
+		Args:    cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := setVar(args[0], strings.Join(args[1:], " ")); err != nil {
+				fmt.Printf("%s\n", t.Error(err))
+			}
+		},
+	})
+
+	showCmd.AddCommand(&cobra.Command{
+		Use:     "vars",
+		Aliases: []string{"variables"},
+		Short:   "Show the variables.",
+		Long:    "Show the variables used to fill in ${name} in requests.",
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			vars := sessionVars()
+			printOutput(vars, func() { displayVars(vars) })
+		},
+	})
+}
+
+// Var flags
+// --var is a root flag, it's set up with the rest of them in initFlags.
+
+var varFlag []string
+
+const varFlagKey = "var"
+
+func parseVarFlag(vs []string) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, v := range vs {
+		i := strings.Index(v, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("--%s %q isn't <name>=<value>", varFlagKey, v)
+		}
+		vars[strings.ToLower(v[:i])] = v[i+1:]
+	}
+	return vars, nil
+}
+
+// applyVarFlags binds a flag to each variable given with --var and applies them. The
+// ones from the app command line last the session, the ones from an interactive command
+// line last the command.
+func applyVarFlags(session bool) {
+	vars, err := parseVarFlag(varFlag)
+	if err != nil {
+		fmt.Printf("%s\n", t.Error(err))
+		return
+	}
+	fs := pflag.NewFlagSet(varFlagKey, pflag.ContinueOnError)
+	for name, value := range vars {
+		fn := varFlagKey + "." + name
+		fs.String(fn, "", "")
+		fs.Set(fn, value)
+		config.Bind(varsKey+"."+name, fs.Lookup(fn))
+	}
+	if session {
+		config.UpdateChangedFlags()
+		config.Apply()
+	} else {
+		config.ApplyFromFlags(fs)
+	}
+}
+
+func setVar(name, value string) error {
+	if !varRE.MatchString("${" + name + "}") {
+		return fmt.Errorf("%q can't be a variable name, use letters, digits, _, . and -", name)
+	}
+	if _, ok := generators[name]; ok {
+		fmt.Printf("%s\n", t.Warn("%s is a generator too, the variable will be used instead of it.", name))
+	}
+	config.Set(varsKey+"."+strings.ToLower(name), value)
+	return nil
+}
+
+// sessionVars are the variables in viper, with this command line's --vars applied.
+func sessionVars() map[string]string {
+	vars := map[string]string{}
+	prefix := varsKey + "."
+	for _, k := range viper.AllKeys() {
+		if v := viper.GetString(k); strings.HasPrefix(k, prefix) && v != "" {
+			vars[strings.TrimPrefix(k, prefix)] = v
+		}
+	}
+	return vars
+}
+
+func displayVars(vars map[string]string) {
+	if len(vars) == 0 {
+		fmt.Printf("%s\n", t.Title("There aren't any variables."))
+		return
+	}
+	names := []string{}
+	for n := range vars {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	w := ansiterm.NewTabWriter(os.Stdout, 4, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\n", t.Title("Name\tValue"))
+	for _, n := range names {
+		fmt.Fprintf(w, "%s\n", t.Text("%s\t%s", n, vars[n]))
+	}
+	w.Flush()
+}
+
+// Filling in
+//
+
+// expandVars fills in the ${name}s in s from vars and the generators.
+// Anything else is left alone.
+func expandVars(s string, vars map[string]string) string {
+	if !strings.Contains(s, "${") {
+		return s
+	}
+	return varRE.ReplaceAllStringFunc(s, func(m string) string {
+		sm := varRE.FindStringSubmatch(m)
+		name, args := sm[1], strings.Fields(sm[2])
+		if len(args) == 0 {
+			if v, ok := vars[name]; ok {
+				return v
+			}
+			if v, ok := vars[strings.ToLower(name)]; ok {
+				return v
+			}
+		}
+		if g, ok := generators[name]; ok {
+			if v, err := g(args); err == nil {
+				return v
+			}
+		}
+		return m
+	})
+}
+
+var generators = map[string]func(args []string) (string, error){
+	"uuid": func(args []string) (string, error) {
+		return newUUID(), nil
+	},
+	"now": func(args []string) (string, error) {
+		now := time.Now().UTC()
+		if len(args) == 1 && args[0] == "unix" {
+			return strconv.FormatInt(now.Unix(), 10), nil
+		}
+		return now.Format(time.RFC3339), nil
+	},
+	"randInt": func(args []string) (string, error) {
+		if len(args) != 2 {
+			return "", fmt.Errorf("use randInt <min> <max>")
+		}
+		min, err1 := strconv.ParseInt(args[0], 10, 64)
+		max, err2 := strconv.ParseInt(args[1], 10, 64)
+		if err1 != nil || err2 != nil {
+			return "", fmt.Errorf("use randInt <min> <max>")
+		}
+		return randInt(min, max)
+	},
+}
+
+// randInt is a random int from min up to max.
+func randInt(min, max int64) (string, error) {
+	if max <= min {
+		return "", fmt.Errorf("max has to be more than min")
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(max-min))
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(min+n.Int64(), 10), nil
+}
+
+// newUUID is a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// In requests
+//
+
+type verbatimKey struct{}
+
+// verbatim marks a request to be sent as it is, without filling in variables.
+func verbatim(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), verbatimKey{}, true))
+}
+
+// fillRequest fills in the variables in the request's path, query values, headers and body.
+func fillRequest(req *http.Request) error {
+	if v, _ := req.Context().Value(verbatimKey{}).(bool); v {
+		return nil
+	}
+	vars := sessionVars()
+
+	if p := expandVars(req.URL.Path, vars); p != req.URL.Path {
+		req.URL.Path, req.URL.RawPath = p, ""
+	}
+	if strings.Contains(req.URL.RawQuery, "${") || strings.Contains(req.URL.RawQuery, "%7B") {
+		q := req.URL.Query()
+		changed := false
+		for k, vs := range q {
+			for i, v := range vs {
+				if f := expandVars(v, vars); f != v {
+					vs[i], changed = f, true
+				}
+			}
+			q[k] = vs
+		}
+		if changed {
+			req.URL.RawQuery = q.Encode()
+		}
+	}
+	for k, vs := range req.Header {
+		for i, v := range vs {
+			vs[i] = expandVars(v, vars)
+		}
+		req.Header[k] = vs
+	}
+
+	body, err := requestBody(req)
+	if err != nil || !bytes.Contains(body, []byte("${")) {
+		return err
+	}
+	filled := []byte(expandVars(string(body), vars))
+	req.Body = ioutil.NopCloser(bytes.NewReader(filled))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(filled)), nil
+	}
+	req.ContentLength = int64(len(filled))
+	return nil
+}
@@ -10,11 +10,14 @@ require (
 	github.com/jdrivas/conman v0.1.7
 	github.com/jdrivas/termtext v0.2.9
 	github.com/jdrivas/vconfig v0.2.5
+	github.com/jmespath/go-jmespath v0.4.0
 	github.com/juju/ansiterm v0.0.0-20180109212912-720a0952cc2a
-	github.com/spf13/cobra v0.0.5
+	github.com/spf13/cobra v1.0.0
 	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.6.1
+	golang.org/x/crypto v0.0.0-20200220183623-bac4c82f6975
 	golang.org/x/sys v0.0.0-20200116001909-b77594299b42 // indirect
+	gopkg.in/yaml.v2 v2.2.8
 )
 
 // replace github.com/jdrivas/conman => /Users/david.rivas/Dropbox/Development/golang/conman